@@ -1,15 +1,33 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"log"
+	"net"
+	stdhttp "net/http"
 	"strings"
+	"time"
 
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
 
+	"github.com/mpolden/echoip/dns"
+	"github.com/mpolden/echoip/grpc"
 	"github.com/mpolden/echoip/http"
 	"github.com/mpolden/echoip/iputil"
 	"github.com/mpolden/echoip/iputil/geo"
+	"github.com/mpolden/echoip/ssh"
+	"github.com/mpolden/echoip/stun"
+	"github.com/mpolden/echoip/tcp"
+	"github.com/mpolden/echoip/useragent"
+	"github.com/mpolden/echoip/whois"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 type multiValueFlag []string
@@ -23,6 +41,18 @@ func (f *multiValueFlag) Set(v string) error {
 	return nil
 }
 
+// splitList splits the comma-separated list s, dropping empty entries and
+// surrounding spaces.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func init() {
 	log.SetPrefix("echoip: ")
 	log.SetFlags(log.Lshortfile)
@@ -32,15 +62,73 @@ func main() {
 	countryFile := flag.String("f", "", "Path to GeoIP country database")
 	cityFile := flag.String("c", "", "Path to GeoIP city database")
 	asnFile := flag.String("a", "", "Path to GeoIP ASN database")
-	listen := flag.String("l", ":8080", "Listening address")
+	var listen multiValueFlag
+	flag.Var(&listen, "l", "Listening address, optionally prefixed by tcp4: or tcp6: to listen on only IPv4 or IPv6 (e.g. tcp4::8080). Use unix:PATH to listen on a Unix socket, and systemd or systemd:NAME to use sockets passed by systemd. Can be repeated (default :8080)")
+	unixSocketMode := flag.String("unix-socket-mode", "0666", "Permissions of Unix sockets, in octal")
+	unixSocketGroup := flag.String("unix-socket-group", "", "Group name or ID owning Unix sockets. Defaults to the group of the process")
 	reverseLookup := flag.Bool("r", false, "Perform reverse hostname lookups")
 	portLookup := flag.Bool("p", false, "Enable port lookup")
+	portLimit := flag.Int("port-limit", 16, "Maximum number of ports checked in a single port lookup")
+	portConcurrency := flag.Int("port-concurrency", 4, "Maximum number of concurrent port checks per client")
+	udpPayload := flag.String("port-udp-payload", "\n", "Payload sent when checking UDP ports without a protocol-specific probe")
+	portTimeout := flag.Duration("port-timeout", 2*time.Second, "Timeout of a single port check")
+	portMaxDials := flag.Int("port-max-dials", 100, "Maximum number of concurrent port checks in total. Set to 0 to disable")
+	portRateLimit := flag.Int("port-rate-limit", 0, "Maximum number of port checks per client per minute. Set to 0 to disable")
+	portAllow := flag.String("port-allow", "", "Comma-separated list of ports and port ranges that can be checked (e.g. 22,80,8000-8100). Defaults to all ports")
+	portDeny := flag.String("port-deny", "", "Comma-separated list of ports and port ranges that cannot be checked")
+	portAllowPrivate := flag.Bool("port-allow-private", false, "Allow checking ports of private, loopback and link-local addresses")
 	template := flag.String("t", "html", "Path to template dir")
 	cacheSize := flag.Int("C", 0, "Size of response cache. Set to 0 to disable")
 	profile := flag.Bool("P", false, "Enables profiling handlers")
 	sponsor := flag.Bool("s", false, "Show sponsor logo")
+	lookupTimeout := flag.Duration("lookup-timeout", 0, "Maximum time spent on geo and hostname lookups per request. Set to 0 to disable")
+	dnsNetwork := flag.String("dns-network", "udp", "Network to use for DNS queries: udp, tcp or tls (DNS-over-TLS)")
+	dnsTimeout := flag.Duration("dns-timeout", 2*time.Second, "Timeout of a single DNS query")
+	dnsRetries := flag.Int("dns-retries", 1, "Number of times a failed DNS query is retried")
+	rateLimitCLI := flag.Int("rate-limit-cli", 0, "Maximum number of requests to plain text endpoints per client per minute. Set to 0 to disable")
+	rateLimitJSON := flag.Int("rate-limit-json", 0, "Maximum number of requests to JSON endpoints per client per minute. Set to 0 to disable")
+	rateLimitPort := flag.Int("rate-limit-port", 0, "Maximum number of requests to port and HTTP check endpoints per client per minute. Set to 0 to disable")
+	readTimeout := flag.Duration("read-timeout", 10*time.Second, "Maximum duration for reading an entire request. Set to 0 to disable")
+	readHeaderTimeout := flag.Duration("read-header-timeout", 5*time.Second, "Maximum duration for reading request headers. Set to 0 to disable")
+	writeTimeout := flag.Duration("write-timeout", 30*time.Second, "Maximum duration before timing out writes of a response. Set to 0 to disable")
+	idleTimeout := flag.Duration("idle-timeout", 120*time.Second, "Maximum time to wait for the next request on a keep-alive connection. Set to 0 to disable")
+	maxHeaderBytes := flag.Int("max-header-bytes", 16<<10, "Maximum size of request headers in bytes")
+	drainDelay := flag.Duration("drain-delay", 0, "Time to report as draining in the health check before shutting down")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 15*time.Second, "Maximum time to wait for in-flight requests to complete when shutting down")
+	var tlsListen multiValueFlag
+	flag.Var(&tlsListen, "tls-listen", "Listening address for HTTPS, if enabled by -tls-cert or -acme-host. Same format as -l (default :8443)")
+	var http3Listen multiValueFlag
+	flag.Var(&http3Listen, "http3-listen", "UDP listening address for HTTP/3, using the certificate of -tls-cert or -acme-host (e.g. :8443). Responses over HTTPS advertise HTTP/3 in the Alt-Svc header. Disabled by default")
+	tlsCert := flag.String("tls-cert", "", "Path to TLS certificate. The certificate is reloaded when the file changes")
+	tlsKey := flag.String("tls-key", "", "Path to TLS private key")
+	tlsRedirect := flag.Bool("tls-redirect", false, "Redirect browsers from plain HTTP to HTTPS. Plain text responses are still served over HTTP")
+	acmeDirectory := flag.String("acme-directory", autocert.DefaultACMEDirectory, "ACME directory URL")
+	acmeEmail := flag.String("acme-email", "", "Contact email address of the ACME account")
+	acmeCache := flag.String("acme-cache", "", "Directory for storing ACME account keys and certificates. Defaults to memory")
+	acmeCA := flag.String("acme-ca", "", "Path to PEM-encoded certificates of the CAs trusted when connecting to the ACME directory. Defaults to the system roots")
+	dualStackIPv4Host := flag.String("dualstack-ipv4-host", "", "IPv4-only host name used to detect the IPv4 address of dual-stack clients (e.g. v4.example.com)")
+	dualStackIPv6Host := flag.String("dualstack-ipv6-host", "", "IPv6-only host name used to detect the IPv6 address of dual-stack clients (e.g. v6.example.com)")
+	dualStackKey := flag.String("dualstack-key", "", "Key for signing dual-stack tokens, which carry the addresses seen so far. Must be shared by all instances behind the same host names. Defaults to a random key")
+	var acmeHosts multiValueFlag
+	flag.Var(&acmeHosts, "acme-host", "Host name to obtain a certificate for using ACME. Enables ACME")
+	var rateLimitExempt multiValueFlag
+	flag.Var(&rateLimitExempt, "rate-limit-exempt", "Network in CIDR notation exempt from rate limiting (e.g. 192.0.2.0/24)")
 	var headers multiValueFlag
 	flag.Var(&headers, "H", "Header to trust for remote IP, if present (e.g. X-Real-IP)")
+	userAgentRules := flag.String("user-agent-rules", "", "Path to a YAML file of rules for parsing User-Agent headers, replacing the embedded rules")
+	redactHeaders := flag.String("redact-headers", "Authorization,Proxy-Authorization,Cookie", "Comma-separated list of headers whose values are redacted by /headers and /request. Set to empty to disable")
+	whoamiDNSListen := flag.String("whoami-dns-listen", "", "Listening address of the DNS server answering queries for -whoami-dns-name with the address of the resolver (e.g. :53). Disabled by default")
+	whoamiDNSName := flag.String("whoami-dns-name", "", "Name answered by the DNS server (e.g. whoami.example.com)")
+	stunListen := flag.String("stun-listen", "", "Listening address of the STUN server, over both UDP and TCP (e.g. :3478). Disabled by default")
+	tcpListen := flag.String("tcp-listen", "", "Listening address of the plain TCP server writing the client IP, for use with nc or telnet (e.g. :2323). Disabled by default")
+	sshListen := flag.String("ssh-listen", "", "Listening address of the SSH server writing the client IP, client version and offered public keys (e.g. :22). Disabled by default")
+	sshHostKey := flag.String("ssh-host-key", "", "Path to the private host key of the SSH server")
+	grpcListen := flag.String("grpc-listen", "", "Listening address of the gRPC API (e.g. :9090). Disabled by default")
+	grpcTrustHeaders := flag.Bool("grpc-trust-headers", false, "Read the remote IP from the -H headers in gRPC request metadata. Only enable when the gRPC API is reachable exclusively through a trusted proxy")
+	whoisListen := flag.String("whois-listen", "", "Listening address of the WHOIS server answering queries for IP addresses (e.g. :43). Disabled by default")
+	tcpVerbose := flag.Bool("tcp-verbose", false, "Write a key/value summary of the client IP on the plain TCP server, instead of only the IP")
+	var dnsServers multiValueFlag
+	flag.Var(&dnsServers, "dns-server", "DNS server to use for reverse lookups (e.g. 192.0.2.1:53). The hosts file is not consulted when set. Defaults to the system resolver")
 	flag.Parse()
 	if len(flag.Args()) != 0 {
 		flag.Usage()
@@ -54,6 +142,13 @@ func main() {
 	cache := http.NewCache(*cacheSize)
 	server := http.New(r, cache, *profile)
 	server.IPHeaders = headers
+	server.RedactHeaders = splitList(*redactHeaders)
+	if *userAgentRules != "" {
+		if server.UserAgentParser, err = useragent.Load(*userAgentRules); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Using User-Agent rules from %s", *userAgentRules)
+	}
 	if _, err := os.Stat(*template); err == nil {
 		server.Template = *template
 	} else {
@@ -62,10 +157,61 @@ func main() {
 	if *reverseLookup {
 		log.Println("Enabling reverse lookup")
 		server.LookupAddr = iputil.LookupAddr
+		server.LookupIP = iputil.LookupIP
+		if len(dnsServers) > 0 {
+			resolver, err := iputil.NewResolver(dnsServers, *dnsNetwork)
+			if err != nil {
+				log.Fatal(err)
+			}
+			resolver.Timeout = *dnsTimeout
+			resolver.Retries = *dnsRetries
+			log.Printf("Using DNS server(s) for reverse lookup: %s (%s)", dnsServers.String(), *dnsNetwork)
+			server.LookupAddr = resolver.LookupAddr
+			server.LookupIP = resolver.LookupIP
+		}
 	}
 	if *portLookup {
 		log.Println("Enabling port lookup")
 		server.LookupPort = iputil.LookupPort
+		server.ProbeTLS = iputil.ProbeTLS
+		server.ProbeBanner = iputil.ProbeBanner
+		server.LookupUDPPort = iputil.LookupUDPPort
+		server.UDPPayload = *udpPayload
+		server.LookupHTTP = iputil.LookupHTTP
+		server.PortLimit = *portLimit
+		server.PortConcurrency = *portConcurrency
+		server.PortTimeout = *portTimeout
+		server.PortMaxDials = *portMaxDials
+		server.PortRateLimit = *portRateLimit
+		server.PortAllowPrivate = *portAllowPrivate
+		if *portAllow != "" {
+			if server.PortAllow, err = http.ParsePorts(*portAllow); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *portDeny != "" {
+			if server.PortDeny, err = http.ParsePorts(*portDeny); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if *lookupTimeout > 0 {
+		log.Printf("Lookup timeout set to %s", *lookupTimeout)
+		server.LookupTimeout = *lookupTimeout
+	}
+	server.RateLimits = make(map[string]http.RateLimit)
+	for group, limit := range map[string]int{"cli": *rateLimitCLI, "json": *rateLimitJSON, "port": *rateLimitPort} {
+		if limit > 0 {
+			log.Printf("Limiting %s requests to %d per minute per client", group, limit)
+			server.RateLimits[group] = http.RateLimit{Rate: float64(limit) / 60, Burst: limit}
+		}
+	}
+	for _, cidr := range rateLimitExempt {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.RateLimitExempt = append(server.RateLimitExempt, network)
 	}
 	if *sponsor {
 		log.Println("Enabling sponsor logo")
@@ -80,8 +226,207 @@ func main() {
 	if *profile {
 		log.Printf("Enabling profiling handlers")
 	}
-	log.Printf("Listening on http://%s", *listen)
-	if err := server.ListenAndServe(*listen); err != nil {
+	if *dualStackIPv4Host != "" && *dualStackIPv6Host != "" {
+		log.Printf("Enabling dual-stack detection using %s and %s", *dualStackIPv4Host, *dualStackIPv6Host)
+		server.DualStackIPv4Host = *dualStackIPv4Host
+		server.DualStackIPv6Host = *dualStackIPv6Host
+		server.DualStackKey = []byte(*dualStackKey)
+	}
+	server.Timeouts = http.Timeouts{
+		Read:       *readTimeout,
+		ReadHeader: *readHeaderTimeout,
+		Write:      *writeTimeout,
+		Idle:       *idleTimeout,
+	}
+	server.MaxHeaderBytes = *maxHeaderBytes
+	server.DrainDelay = *drainDelay
+
+	var tlsConfig *tls.Config
+	if len(acmeHosts) > 0 {
+		log.Printf("Obtaining certificates for %s from %s", acmeHosts.String(), *acmeDirectory)
+		config := http.ACMEConfig{
+			Hosts:     acmeHosts,
+			Email:     *acmeEmail,
+			Directory: *acmeDirectory,
+			CacheDir:  *acmeCache,
+		}
+		if *acmeCA != "" {
+			roots, err := http.LoadCertPool(*acmeCA)
+			if err != nil {
+				log.Fatal(err)
+			}
+			config.RootCAs = roots
+		}
+		m := http.NewACMEManager(config)
+		// TLS-ALPN-01 challenges are answered by the TLS listener, and HTTP-01
+		// challenges by the plain HTTP listener
+		tlsConfig = m.TLSConfig()
+		server.ACMEChallenge = m.HTTPHandler
+	} else if *tlsCert != "" {
+		certs, err := http.NewCertReloader(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Loaded certificate from %s", *tlsCert)
+		tlsConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+	}
+	mode, err := strconv.ParseUint(*unixSocketMode, 8, 32)
+	if err != nil {
+		log.Fatalf("invalid unix socket mode: %s", *unixSocketMode)
+	}
+	server.UnixSocketMode = os.FileMode(mode)
+	server.UnixSocketGroup = *unixSocketGroup
+	if len(listen) == 0 {
+		listen = multiValueFlag{":8080"}
+	}
+	if len(tlsListen) == 0 {
+		tlsListen = multiValueFlag{":8443"}
+	}
+	if tlsConfig != nil && *tlsRedirect {
+		// The public port of Unix and systemd sockets is unknown, assume the
+		// default
+		port := "443"
+		if network, addr := http.ParseListenAddr(tlsListen[0]); strings.HasPrefix(network, "tcp") {
+			var err error
+			if _, port, err = net.SplitHostPort(addr); err != nil {
+				log.Fatal(err)
+			}
+		}
+		log.Printf("Redirecting browsers to HTTPS on port %s", port)
+		server.TLSRedirectPort = port
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errCh := make(chan error)
+	running := 0
+	serve := func(f func() error) {
+		running++
+		go func() { errCh <- f() }()
+	}
+	// Servers other than the HTTP server, stopped by closing them
+	var closers []func() error
+	// Servers other than the HTTP server, stopped gracefully along with it
+	var shutdowns []func(context.Context) error
+	for _, l := range listen {
+		network, addr := http.ParseListenAddr(l)
+		serve(func() error {
+			log.Printf("Listening for HTTP on %s", l)
+			return server.ListenAndServe(network, addr)
+		})
+	}
+	if tlsConfig != nil {
+		for _, l := range tlsListen {
+			network, addr := http.ParseListenAddr(l)
+			serve(func() error {
+				log.Printf("Listening for HTTPS on %s", l)
+				return server.ListenAndServeTLS(network, addr, tlsConfig)
+			})
+		}
+		for _, addr := range http3Listen {
+			serve(func() error {
+				log.Printf("Listening for HTTP/3 on %s", addr)
+				return server.ListenAndServeHTTP3(addr, tlsConfig)
+			})
+		}
+	} else if len(http3Listen) > 0 {
+		log.Fatal("-tls-cert or -acme-host must be set when -http3-listen is set")
+	}
+	if *whoamiDNSListen != "" {
+		if *whoamiDNSName == "" {
+			log.Fatal("-whoami-dns-name must be set when -whoami-dns-listen is set")
+		}
+		dnsServer := dns.New(*whoamiDNSName, r)
+		closers = append(closers, dnsServer.Close)
+		serve(func() error {
+			log.Printf("Listening for DNS on %s, answering queries for %s", *whoamiDNSListen, *whoamiDNSName)
+			return dnsServer.ListenAndServe(*whoamiDNSListen)
+		})
+	}
+	if *stunListen != "" {
+		stunServer := stun.New()
+		closers = append(closers, stunServer.Close)
+		serve(func() error {
+			log.Printf("Listening for STUN on %s", *stunListen)
+			return stunServer.ListenAndServe(*stunListen)
+		})
+	}
+	if *tcpListen != "" {
+		var lookup func(context.Context, net.IP) (http.Response, error)
+		if *tcpVerbose {
+			lookup = server.Lookup
+		}
+		tcpServer := tcp.New(lookup)
+		closers = append(closers, tcpServer.Close)
+		serve(func() error {
+			log.Printf("Listening for TCP on %s", *tcpListen)
+			return tcpServer.ListenAndServe(*tcpListen)
+		})
+	}
+	if *whoisListen != "" {
+		whoisServer := whois.New(server.Lookup)
+		closers = append(closers, whoisServer.Close)
+		serve(func() error {
+			log.Printf("Listening for WHOIS on %s", *whoisListen)
+			return whoisServer.ListenAndServe(*whoisListen)
+		})
+	}
+	if *sshListen != "" {
+		if *sshHostKey == "" {
+			log.Fatal("-ssh-host-key must be set when -ssh-listen is set")
+		}
+		sshServer, err := ssh.New(*sshHostKey, server.Lookup)
+		if err != nil {
+			log.Fatal(err)
+		}
+		closers = append(closers, sshServer.Close)
+		serve(func() error {
+			log.Printf("Listening for SSH on %s", *sshListen)
+			return sshServer.ListenAndServe(*sshListen)
+		})
+	}
+	if *grpcListen != "" {
+		grpcServer := grpc.New(server)
+		grpcServer.TrustHeaders = *grpcTrustHeaders
+		shutdowns = append(shutdowns, grpcServer.Shutdown)
+		serve(func() error {
+			log.Printf("Listening for gRPC on %s", *grpcListen)
+			return grpcServer.ListenAndServe(*grpcListen)
+		})
+	}
+
+	select {
+	case err := <-errCh:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	stop()
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", *drainDelay+*shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *drainDelay+*shutdownTimeout)
+	defer cancel()
+	for _, c := range closers {
+		if err := c(); err != nil {
+			log.Print(err)
+		}
+	}
+	var wg sync.WaitGroup
+	for _, shutdown := range shutdowns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := shutdown(shutdownCtx); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+	err = server.Shutdown(shutdownCtx)
+	wg.Wait()
+	if err != nil {
 		log.Fatal(err)
 	}
+	for i := 0; i < running; i++ {
+		if err := <-errCh; err != nil && !errors.Is(err, stdhttp.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}
 }
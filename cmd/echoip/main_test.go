@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestMultiValueFlagString(t *testing.T) {
 	var xmvf = []struct {
@@ -40,3 +43,20 @@ func TestMultiValueFlagString(t *testing.T) {
 		}
 	}
 }
+
+func TestSplitList(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out []string
+	}{
+		{"", nil},
+		{" , ,", nil},
+		{"Authorization", []string{"Authorization"}},
+		{"Authorization, Cookie ,,X-Api-Key", []string{"Authorization", "Cookie", "X-Api-Key"}},
+	}
+	for _, tt := range tests {
+		if got := splitList(tt.in); !reflect.DeepEqual(got, tt.out) {
+			t.Errorf("splitList(%q) = %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
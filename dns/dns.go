@@ -0,0 +1,405 @@
+// Package dns implements a DNS server answering queries for a single name with
+// the address of the querying resolver, similar to o-o.myaddr.l.google.com.
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mpolden/echoip/internal/listener"
+	"github.com/mpolden/echoip/iputil/geo"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// Maximum size of UDP responses to queries without EDNS
+	minUDPSize = 512
+	// Maximum size of UDP responses to queries with EDNS. See
+	// https://www.dnsflagday.net/2020/
+	maxUDPSize = 1232
+	// EDNS Client Subnet option code, as defined by RFC 7871
+	optionClientSubnet = 8
+	// Maximum time spent on geo lookups per query
+	lookupTimeout = time.Second
+	// Maximum time a TCP connection may be idle
+	tcpIdleTimeout = 10 * time.Second
+	// Maximum number of UDP queries handled concurrently
+	maxUDPQueries = 256
+)
+
+// Server is a DNS server answering queries for a single name. TXT queries are
+// answered with the address of the resolver, its country and ASN, and the
+// client subnet sent by the resolver, if any. A and AAAA queries are answered
+// with the address of the resolver.
+type Server struct {
+	name string
+	gr   geo.Reader
+
+	listeners listener.Tracker
+}
+
+// New creates a new server answering queries for name, using gr to look up
+// country and ASN.
+func New(name string, gr geo.Reader) *Server {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return &Server{name: name, gr: gr}
+}
+
+// ListenAndServe listens on addr, over both UDP and TCP, and serves queries
+// until Close is called.
+func (s *Server) ListenAndServe(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	errCh := make(chan error, 2)
+	go func() { errCh <- s.ServeUDP(pc) }()
+	go func() { errCh <- s.ServeTCP(l) }()
+	err = <-errCh
+	if err != nil {
+		// Stop the other listener
+		pc.Close()
+		l.Close()
+	}
+	return err
+}
+
+// Close stops the server.
+func (s *Server) Close() error { return s.listeners.Close() }
+
+// ServeUDP serves queries received on pc until Close is called.
+func (s *Server) ServeUDP(pc net.PacketConn) error {
+	if !s.listeners.Track(pc) {
+		return nil
+	}
+	buf := make([]byte, 65535)
+	sem := make(chan struct{}, maxUDPQueries)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if s.listeners.Closed() {
+				return nil
+			}
+			return err
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			// Drop the query when busy. Resolvers retry unanswered queries
+			continue
+		}
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		go func() {
+			defer func() { <-sem }()
+			if response := s.handle(query, udpAddr.IP, true); response != nil {
+				pc.WriteTo(response, addr)
+			}
+		}()
+	}
+}
+
+// ServeTCP serves queries received on connections accepted by l until Close is
+// called.
+func (s *Server) ServeTCP(l net.Listener) error {
+	return s.listeners.Serve(l, s.serveConn)
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	var size [2]byte
+	for {
+		conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+		response := s.handle(query, addr.IP, false)
+		if response == nil {
+			return
+		}
+		if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(response)))); err != nil {
+			return
+		}
+		if _, err := conn.Write(response); err != nil {
+			return
+		}
+	}
+}
+
+// query is a parsed DNS query.
+type query struct {
+	header     dnsmessage.Header
+	question   dnsmessage.Question
+	edns       bool
+	udpSize    int
+	subnet     *dnsmessage.Option
+	badVersion bool
+}
+
+func parseQuery(b []byte) (query, error) {
+	var q query
+	var p dnsmessage.Parser
+	h, err := p.Start(b)
+	if err != nil {
+		return q, err
+	}
+	q.header = h
+	if q.question, err = p.Question(); err != nil {
+		return q, err
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return q, err
+	}
+	if err := p.SkipAllAnswers(); err != nil {
+		return q, err
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return q, err
+	}
+	for {
+		rh, err := p.AdditionalHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		} else if err != nil {
+			return q, err
+		}
+		if rh.Type != dnsmessage.TypeOPT {
+			if err := p.SkipAdditional(); err != nil {
+				return q, err
+			}
+			continue
+		}
+		opt, err := p.OPTResource()
+		if err != nil {
+			return q, err
+		}
+		q.edns = true
+		q.udpSize = int(rh.Class)
+		// The EDNS version is stored in the second byte of the TTL
+		q.badVersion = rh.TTL&0x00ff0000 != 0
+		for i, o := range opt.Options {
+			if o.Code == optionClientSubnet {
+				q.subnet = &opt.Options[i]
+			}
+		}
+	}
+	return q, nil
+}
+
+// clientSubnet parses the EDNS Client Subnet option o, returning the subnet
+// and an option echoing it with a scope prefix length of zero, as the answer
+// does not depend on the client subnet.
+func clientSubnet(o dnsmessage.Option) (*net.IPNet, dnsmessage.Option, error) {
+	if len(o.Data) < 4 {
+		return nil, o, errors.New("client subnet option too short")
+	}
+	family := binary.BigEndian.Uint16(o.Data)
+	prefix := int(o.Data[2])
+	var ip net.IP
+	switch family {
+	case 1:
+		ip = make(net.IP, net.IPv4len)
+	case 2:
+		ip = make(net.IP, net.IPv6len)
+	default:
+		return nil, o, fmt.Errorf("invalid client subnet family: %d", family)
+	}
+	addr := o.Data[4:]
+	if prefix > len(ip)*8 || len(addr) != (prefix+7)/8 {
+		return nil, o, errors.New("invalid client subnet prefix")
+	}
+	copy(ip, addr)
+	data := append([]byte(nil), o.Data...)
+	data[3] = 0
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(prefix, len(ip)*8)}, dnsmessage.Option{Code: optionClientSubnet, Data: data}, nil
+}
+
+// handle returns the response to the query in b, received from ip. It returns
+// nil if the query should be ignored.
+func (s *Server) handle(b []byte, ip net.IP, udp bool) []byte {
+	q, err := parseQuery(b)
+	if err != nil {
+		if q.header.Response || len(b) < 12 {
+			return nil
+		}
+		return s.errorResponse(q, dnsmessage.RCodeFormatError)
+	}
+	if q.header.Response {
+		return nil
+	}
+	if q.header.OpCode != 0 {
+		return s.errorResponse(q, dnsmessage.RCodeNotImplemented)
+	}
+	var subnet *net.IPNet
+	var subnetOpt *dnsmessage.Option
+	if q.subnet != nil {
+		var opt dnsmessage.Option
+		if subnet, opt, err = clientSubnet(*q.subnet); err != nil {
+			return s.errorResponse(q, dnsmessage.RCodeFormatError)
+		}
+		subnetOpt = &opt
+	}
+	rcode := dnsmessage.RCodeSuccess
+	var answers []dnsmessage.Resource
+	if !strings.EqualFold(q.question.Name.String(), s.name) {
+		rcode = dnsmessage.RCodeRefused
+	} else if q.question.Class == dnsmessage.ClassINET || q.question.Class == dnsmessage.ClassANY {
+		answers = s.answers(q.question, ip, subnet)
+	}
+	limit := 0
+	if udp {
+		limit = minUDPSize
+		if q.edns {
+			limit = min(max(q.udpSize, minUDPSize), maxUDPSize)
+		}
+	}
+	response, err := s.pack(q, rcode, answers, subnetOpt, false)
+	if err == nil && limit > 0 && len(response) > limit {
+		response, err = s.pack(q, rcode, nil, subnetOpt, true)
+	}
+	if err != nil {
+		log.Printf("dns: failed to pack response: %s", err)
+		return nil
+	}
+	return response
+}
+
+func (s *Server) errorResponse(q query, rcode dnsmessage.RCode) []byte {
+	b, err := s.pack(q, rcode, nil, nil, false)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (s *Server) answers(question dnsmessage.Question, ip net.IP, subnet *net.IPNet) []dnsmessage.Resource {
+	// Answers depend on the resolver and must not be cached
+	header := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 0}
+	var answers []dnsmessage.Resource
+	if ip4 := ip.To4(); ip4 != nil && (question.Type == dnsmessage.TypeA || question.Type == dnsmessage.TypeALL) {
+		answers = append(answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: [4]byte(ip4)}})
+	}
+	if ip.To4() == nil && (question.Type == dnsmessage.TypeAAAA || question.Type == dnsmessage.TypeALL) {
+		answers = append(answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: [16]byte(ip.To16())}})
+	}
+	if question.Type == dnsmessage.TypeTXT || question.Type == dnsmessage.TypeALL {
+		for _, txt := range s.txt(ip, subnet) {
+			answers = append(answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.TXTResource{TXT: []string{txt}}})
+		}
+	}
+	return answers
+}
+
+// txt returns the TXT records describing ip.
+func (s *Server) txt(ip net.IP, subnet *net.IPNet) []string {
+	records := []string{ip.String()}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	if country, err := s.gr.Country(ctx, ip); err == nil && country.ISO != "" {
+		records = append(records, truncate(fmt.Sprintf("country %s %s", country.ISO, country.Name)))
+	}
+	if asn, err := s.gr.ASN(ctx, ip); err == nil && asn.AutonomousSystemNumber > 0 {
+		records = append(records, truncate(fmt.Sprintf("asn AS%d %s", asn.AutonomousSystemNumber, asn.AutonomousSystemOrganization)))
+	}
+	if subnet != nil {
+		records = append(records, "edns0-client-subnet "+subnet.String())
+	}
+	return records
+}
+
+// truncate truncates s to the maximum length of a TXT character string.
+func truncate(s string) string {
+	if len(s) > 255 {
+		return s[:255]
+	}
+	return strings.TrimSpace(s)
+}
+
+func (s *Server) pack(q query, rcode dnsmessage.RCode, answers []dnsmessage.Resource, subnet *dnsmessage.Option, truncated bool) ([]byte, error) {
+	if q.badVersion {
+		// Extended RCode BADVERS, see RFC 6891
+		rcode = 16
+		answers = nil
+		subnet = nil
+	}
+	header := dnsmessage.Header{
+		ID:               q.header.ID,
+		Response:         true,
+		OpCode:           q.header.OpCode,
+		Authoritative:    rcode != dnsmessage.RCodeRefused,
+		Truncated:        truncated,
+		RecursionDesired: q.header.RecursionDesired,
+		RCode:            rcode & 0xf,
+	}
+	b := dnsmessage.NewBuilder(nil, header)
+	b.EnableCompression()
+	if q.question.Name.Length > 0 {
+		if err := b.StartQuestions(); err != nil {
+			return nil, err
+		}
+		if err := b.Question(q.question); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	for _, answer := range answers {
+		var err error
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			err = b.AResource(answer.Header, *body)
+		case *dnsmessage.AAAAResource:
+			err = b.AAAAResource(answer.Header, *body)
+		case *dnsmessage.TXTResource:
+			err = b.TXTResource(answer.Header, *body)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if q.edns {
+		if err := b.StartAdditionals(); err != nil {
+			return nil, err
+		}
+		var rh dnsmessage.ResourceHeader
+		if err := rh.SetEDNS0(maxUDPSize, rcode, false); err != nil {
+			return nil, err
+		}
+		var opt dnsmessage.OPTResource
+		if subnet != nil {
+			opt.Options = append(opt.Options, *subnet)
+		}
+		if err := b.OPTResource(rh, opt); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
@@ -0,0 +1,268 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mpolden/echoip/iputil/geo"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type testDb struct{}
+
+func (t *testDb) Country(context.Context, net.IP) (geo.Country, error) {
+	return geo.Country{Name: "Elbonia", ISO: "EB"}, nil
+}
+
+func (t *testDb) City(context.Context, net.IP) (geo.City, error) {
+	return geo.City{}, nil
+}
+
+func (t *testDb) ASN(context.Context, net.IP) (geo.ASN, error) {
+	return geo.ASN{AutonomousSystemNumber: 59795, AutonomousSystemOrganization: "Hosting4Real"}, nil
+}
+
+func (t *testDb) IsEmpty() bool { return false }
+
+type testQuery struct {
+	name    string
+	qtype   dnsmessage.Type
+	edns    bool
+	udpSize uint16
+	subnet  []byte
+}
+
+func (q testQuery) pack(t *testing.T) []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 4242, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(q.name), Type: q.qtype, Class: dnsmessage.ClassINET})
+	if q.edns {
+		b.StartAdditionals()
+		var rh dnsmessage.ResourceHeader
+		rh.SetEDNS0(int(q.udpSize), dnsmessage.RCodeSuccess, false)
+		var opt dnsmessage.OPTResource
+		if q.subnet != nil {
+			opt.Options = []dnsmessage.Option{{Code: optionClientSubnet, Data: q.subnet}}
+		}
+		b.OPTResource(rh, opt)
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func parseResponse(t *testing.T, b []byte) dnsmessage.Message {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func txtRecords(msg dnsmessage.Message) []string {
+	var records []string
+	for _, answer := range msg.Answers {
+		if txt, ok := answer.Body.(*dnsmessage.TXTResource); ok {
+			records = append(records, strings.Join(txt.TXT, ""))
+		}
+	}
+	return records
+}
+
+func TestHandle(t *testing.T) {
+	s := New("whoami.example.com", &testDb{})
+	ipv4 := net.ParseIP("192.0.2.1")
+	ipv6 := net.ParseIP("2001:db8::1")
+	// Family 1 (IPv4), source prefix 24, scope prefix 0, followed by the first
+	// three bytes of the address
+	subnet := []byte{0, 1, 24, 0, 198, 51, 100}
+	var tests = []struct {
+		query   testQuery
+		ip      net.IP
+		rcode   dnsmessage.RCode
+		answers []string
+		subnet  []byte
+	}{
+		{testQuery{name: "whoami.example.com.", qtype: dnsmessage.TypeTXT}, ipv4, dnsmessage.RCodeSuccess, []string{"192.0.2.1", "country EB Elbonia", "asn AS59795 Hosting4Real"}, nil},
+		{testQuery{name: "WHOAMI.example.com.", qtype: dnsmessage.TypeTXT}, ipv6, dnsmessage.RCodeSuccess, []string{"2001:db8::1", "country EB Elbonia", "asn AS59795 Hosting4Real"}, nil},
+		{testQuery{name: "whoami.example.com.", qtype: dnsmessage.TypeA}, ipv4, dnsmessage.RCodeSuccess, []string{"192.0.2.1"}, nil},
+		{testQuery{name: "whoami.example.com.", qtype: dnsmessage.TypeA}, ipv6, dnsmessage.RCodeSuccess, nil, nil},
+		{testQuery{name: "whoami.example.com.", qtype: dnsmessage.TypeAAAA}, ipv6, dnsmessage.RCodeSuccess, []string{"2001:db8::1"}, nil},
+		{testQuery{name: "whoami.example.com.", qtype: dnsmessage.TypeMX}, ipv4, dnsmessage.RCodeSuccess, nil, nil},
+		{testQuery{name: "example.com.", qtype: dnsmessage.TypeTXT}, ipv4, dnsmessage.RCodeRefused, nil, nil},
+		{testQuery{name: "whoami.example.com.", qtype: dnsmessage.TypeTXT, edns: true, udpSize: 4096, subnet: subnet}, ipv4, dnsmessage.RCodeSuccess, []string{"192.0.2.1", "country EB Elbonia", "asn AS59795 Hosting4Real", "edns0-client-subnet 198.51.100.0/24"}, subnet},
+		{testQuery{name: "whoami.example.com.", qtype: dnsmessage.TypeTXT, edns: true, udpSize: 4096, subnet: []byte{0, 1, 24, 0, 198}}, ipv4, dnsmessage.RCodeFormatError, nil, nil},
+	}
+	for i, tt := range tests {
+		msg := parseResponse(t, s.handle(tt.query.pack(t), tt.ip, true))
+		if msg.ID != 4242 || !msg.Response || !msg.RecursionDesired {
+			t.Errorf("#%d: unexpected header: %+v", i, msg.Header)
+		}
+		if msg.RCode != tt.rcode {
+			t.Errorf("#%d: want rcode %s, got %s", i, tt.rcode, msg.RCode)
+		}
+		var answers []string
+		for _, answer := range msg.Answers {
+			if answer.Header.TTL != 0 {
+				t.Errorf("#%d: want TTL 0, got %d", i, answer.Header.TTL)
+			}
+			switch body := answer.Body.(type) {
+			case *dnsmessage.AResource:
+				answers = append(answers, net.IP(body.A[:]).String())
+			case *dnsmessage.AAAAResource:
+				answers = append(answers, net.IP(body.AAAA[:]).String())
+			case *dnsmessage.TXTResource:
+				answers = append(answers, strings.Join(body.TXT, ""))
+			}
+		}
+		if !reflect.DeepEqual(answers, tt.answers) {
+			t.Errorf("#%d: want answers %q, got %q", i, tt.answers, answers)
+		}
+		if !tt.query.edns {
+			if len(msg.Additionals) > 0 {
+				t.Errorf("#%d: want no additionals, got %d", i, len(msg.Additionals))
+			}
+			continue
+		}
+		if len(msg.Additionals) != 1 {
+			t.Fatalf("#%d: want OPT record, got %d additionals", i, len(msg.Additionals))
+		}
+		opt, ok := msg.Additionals[0].Body.(*dnsmessage.OPTResource)
+		if !ok {
+			t.Fatalf("#%d: want OPT record, got %T", i, msg.Additionals[0].Body)
+		}
+		var echoed []byte
+		for _, o := range opt.Options {
+			if o.Code == optionClientSubnet {
+				echoed = o.Data
+			}
+		}
+		if !reflect.DeepEqual(echoed, tt.subnet) {
+			t.Errorf("#%d: want client subnet %v, got %v", i, tt.subnet, echoed)
+		}
+	}
+
+	// Responses are ignored
+	response := s.handle(testQuery{name: "whoami.example.com.", qtype: dnsmessage.TypeTXT}.pack(t), ipv4, true)
+	if s.handle(response, ipv4, true) != nil {
+		t.Error("expected response to be ignored")
+	}
+	if s.handle([]byte{1, 2, 3}, ipv4, true) != nil {
+		t.Error("expected short message to be ignored")
+	}
+}
+
+type longDb struct{ testDb }
+
+func (t *longDb) Country(context.Context, net.IP) (geo.Country, error) {
+	return geo.Country{Name: strings.Repeat("a", 300), ISO: "EB"}, nil
+}
+
+func (t *longDb) ASN(context.Context, net.IP) (geo.ASN, error) {
+	return geo.ASN{AutonomousSystemNumber: 59795, AutonomousSystemOrganization: strings.Repeat("b", 300)}, nil
+}
+
+func TestHandleTruncated(t *testing.T) {
+	s := New("whoami.example.com", &longDb{})
+	q := testQuery{name: "whoami.example.com.", qtype: dnsmessage.TypeTXT}
+	ip := net.ParseIP("2001:db8::1")
+
+	udp := parseResponse(t, s.handle(q.pack(t), ip, true))
+	tcp := parseResponse(t, s.handle(q.pack(t), ip, false))
+	if len(s.handle(q.pack(t), ip, false)) <= minUDPSize {
+		t.Fatal("expected response larger than 512 bytes")
+	}
+	if !udp.Truncated || len(udp.Answers) != 0 {
+		t.Errorf("want truncated response without answers over UDP, got truncated=%t with %d answers", udp.Truncated, len(udp.Answers))
+	}
+	for _, txt := range txtRecords(tcp) {
+		if len(txt) > 255 {
+			t.Errorf("want TXT record of at most 255 bytes, got %d", len(txt))
+		}
+	}
+	if tcp.Truncated || len(tcp.Answers) != 3 {
+		t.Errorf("want full response over TCP, got truncated=%t with %d answers", tcp.Truncated, len(tcp.Answers))
+	}
+	q.edns = true
+	q.udpSize = 4096
+	if edns := parseResponse(t, s.handle(q.pack(t), ip, true)); edns.Truncated {
+		t.Error("want full response over UDP with EDNS")
+	}
+}
+
+func TestServe(t *testing.T) {
+	s := New("whoami.example.com", &testDb{})
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 2)
+	go func() { errCh <- s.ServeUDP(pc) }()
+	go func() { errCh <- s.ServeTCP(l) }()
+	query := testQuery{name: "whoami.example.com.", qtype: dnsmessage.TypeTXT}.pack(t)
+	want := []string{"127.0.0.1", "country EB Elbonia", "asn AS59795 Hosting4Real"}
+
+	// UDP
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(query); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := txtRecords(parseResponse(t, buf[:n])); !reflect.DeepEqual(got, want) {
+		t.Errorf("UDP: want %q, got %q", want, got)
+	}
+
+	// TCP, with two queries on the same connection
+	tcpConn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+	tcpConn.SetDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 2; i++ {
+		if _, err := tcpConn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+			t.Fatal(err)
+		}
+		var size [2]byte
+		if _, err := io.ReadFull(tcpConn, size[:]); err != nil {
+			t.Fatal(err)
+		}
+		response := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(tcpConn, response); err != nil {
+			t.Fatal(err)
+		}
+		if got := txtRecords(parseResponse(t, response)); !reflect.DeepEqual(got, want) {
+			t.Errorf("TCP #%d: want %q, got %q", i, want, got)
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errCh; err != nil {
+			t.Errorf("want nil error after close, got %s", err)
+		}
+	}
+}
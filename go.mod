@@ -2,9 +2,21 @@ module github.com/mpolden/echoip
 
 go 1.24
 
-require github.com/oschwald/geoip2-golang v1.13.0
+require (
+	github.com/oschwald/geoip2-golang v1.13.0
+	github.com/oschwald/maxminddb-golang v1.13.0
+	github.com/quic-go/quic-go v0.56.0
+	golang.org/x/crypto v0.41.0
+	golang.org/x/net v0.43.0
+	google.golang.org/grpc v1.75.1
+	google.golang.org/protobuf v1.36.6
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
-	github.com/oschwald/maxminddb-golang v1.13.0 // indirect
-	golang.org/x/sys v0.20.0 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/quic-go/qpack v0.5.1 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250707201910-8d1bb00bc6a7 // indirect
 )
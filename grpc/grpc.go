@@ -0,0 +1,277 @@
+// Package grpc implements a gRPC API mirroring the HTTP API, with health
+// checking and reflection.
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/mpolden/echoip/grpc/pb"
+	"github.com/mpolden/echoip/http"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server is a gRPC server using the lookup and port checking logic of an HTTP
+// server.
+type Server struct {
+	pb.UnimplementedEchoIPServer
+	// TrustHeaders determines whether the address of the caller is read from
+	// the metadata keys corresponding to the trusted headers of the HTTP
+	// server. It should only be set when all requests pass through a trusted
+	// proxy, as callers can otherwise claim any address.
+	TrustHeaders bool
+	server       *http.Server
+	grpc         *gogrpc.Server
+	health       *health.Server
+}
+
+// New creates a new gRPC server backed by server.
+func New(server *http.Server) *Server {
+	s := &Server{server: server, grpc: gogrpc.NewServer(), health: health.NewServer()}
+	pb.RegisterEchoIPServer(s.grpc, s)
+	healthpb.RegisterHealthServer(s.grpc, s.health)
+	reflection.Register(s.grpc)
+	s.health.SetServingStatus(pb.EchoIP_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	return s
+}
+
+// ListenAndServe listens on addr and serves requests until Close is called.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves requests received on connections accepted by l until Close is
+// called.
+func (s *Server) Serve(l net.Listener) error {
+	err := s.grpc.Serve(l)
+	if errors.Is(err, gogrpc.ErrServerStopped) {
+		return nil
+	}
+	return err
+}
+
+// Close stops the server immediately, cancelling in-flight RPCs.
+func (s *Server) Close() error {
+	s.health.Shutdown()
+	s.grpc.Stop()
+	return nil
+}
+
+// Shutdown gracefully stops the server, waiting for in-flight RPCs to complete.
+// If ctx is done first, the remaining RPCs are cancelled and the error of ctx
+// is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.health.Shutdown()
+	done := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.grpc.Stop()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// allow counts a request of the caller against the rate limit of the named
+// group of HTTP routes.
+func (s *Server) allow(ctx context.Context, group string) error {
+	ip, err := s.clientIP(ctx)
+	if err != nil {
+		return err
+	}
+	if ok, _ := s.server.AllowRequest(group, ip); !ok {
+		return status.Error(codes.ResourceExhausted, "too many requests, please try again later")
+	}
+	return nil
+}
+
+// WhoAmI returns information about the address of the caller.
+func (s *Server) WhoAmI(ctx context.Context, _ *pb.WhoAmIRequest) (*pb.Response, error) {
+	if err := s.allow(ctx, "json"); err != nil {
+		return nil, err
+	}
+	ip, err := s.clientIP(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.lookup(ctx, ip)
+}
+
+// Lookup returns information about the requested address.
+func (s *Server) Lookup(ctx context.Context, req *pb.LookupRequest) (*pb.Response, error) {
+	if err := s.allow(ctx, "json"); err != nil {
+		return nil, err
+	}
+	ip, err := parseIP(req.GetIp())
+	if err != nil {
+		return nil, err
+	}
+	return s.lookup(ctx, ip)
+}
+
+// BatchLookup returns information about each address received on stream. Each
+// address counts as one request against the rate limit.
+func (s *Server) BatchLookup(stream pb.EchoIP_BatchLookupServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := s.allow(stream.Context(), "json"); err != nil {
+			return err
+		}
+		ip, err := parseIP(req.GetIp())
+		if err != nil {
+			return err
+		}
+		response, err := s.lookup(stream.Context(), ip)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+}
+
+// CheckPort checks whether the requested port is reachable on the address of
+// the caller.
+func (s *Server) CheckPort(ctx context.Context, req *pb.CheckPortRequest) (*pb.PortResponse, error) {
+	if err := s.allow(ctx, "port"); err != nil {
+		return nil, err
+	}
+	ip, err := s.clientIP(ctx)
+	if err != nil {
+		return nil, err
+	}
+	response, err := s.server.CheckPort(ctx, ip, uint64(req.GetPort()), req.GetProtocol(), req.GetProbe())
+	switch {
+	case err == nil:
+	case errors.Is(err, http.ErrPortForbidden):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, http.ErrPortRateLimited):
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return nil, status.FromContextError(err).Err()
+	default:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return portResponse(response), nil
+}
+
+func (s *Server) lookup(ctx context.Context, ip net.IP) (*pb.Response, error) {
+	response, err := s.server.Lookup(ctx, ip)
+	if err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	return lookupResponse(response), nil
+}
+
+// clientIP returns the address of the caller. If TrustHeaders is set, the first
+// trusted header present in the request metadata is preferred.
+func (s *Server) clientIP(ctx context.Context) (net.IP, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var headers []string
+	if s.TrustHeaders {
+		headers = s.server.IPHeaders
+	}
+	for _, header := range headers {
+		values := md.Get(header)
+		if len(values) == 0 || values[0] == "" {
+			continue
+		}
+		value := values[0]
+		if strings.EqualFold(header, "X-Forwarded-For") {
+			value, _, _ = strings.Cut(value, ",")
+		}
+		if ip := net.ParseIP(strings.TrimSpace(value)); ip != nil {
+			return ip, nil
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "could not parse IP: %s", value)
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if addr, ok := p.Addr.(*net.TCPAddr); ok {
+			return addr.IP, nil
+		}
+	}
+	return nil, status.Error(codes.InvalidArgument, "could not determine IP of caller")
+}
+
+func parseIP(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid IP: %q", s)
+	}
+	return ip, nil
+}
+
+func lookupResponse(r http.Response) *pb.Response {
+	response := &pb.Response{
+		Ip:               r.IP.String(),
+		Country:          r.Country,
+		CountryIso:       r.CountryISO,
+		CountryEu:        r.CountryEU,
+		RegionName:       r.RegionName,
+		RegionCode:       r.RegionCode,
+		MetroCode:        uint32(r.MetroCode),
+		ZipCode:          r.PostalCode,
+		City:             r.City,
+		Latitude:         r.Latitude,
+		Longitude:        r.Longitude,
+		TimeZone:         r.Timezone,
+		Asn:              r.ASN,
+		AsnOrg:           r.ASNOrg,
+		Network:          r.Network,
+		Hostname:         r.Hostname,
+		Hostnames:        r.Hostnames,
+		HostnameVerified: r.HostnameVerified,
+	}
+	if r.IPDecimal != nil {
+		response.IpDecimal = r.IPDecimal.String()
+	}
+	return response
+}
+
+func portResponse(r http.PortResponse) *pb.PortResponse {
+	response := &pb.PortResponse{
+		Ip:         r.IP.String(),
+		Port:       uint32(r.Port),
+		Protocol:   r.Protocol,
+		Reachable:  r.Reachable,
+		State:      r.State,
+		Banner:     r.Banner,
+		ProbeError: r.ProbeError,
+	}
+	if r.TLS != nil {
+		response.Tls = &pb.PortTLS{
+			Version:  r.TLS.Version,
+			Alpn:     r.TLS.ALPN,
+			Subject:  r.TLS.Subject,
+			Sans:     r.TLS.SANs,
+			NotAfter: timestamppb.New(r.TLS.NotAfter),
+		}
+	}
+	return response
+}
@@ -0,0 +1,250 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mpolden/echoip/grpc/pb"
+	"github.com/mpolden/echoip/http"
+	"github.com/mpolden/echoip/iputil/geo"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type testDb struct{}
+
+func (t *testDb) Country(context.Context, net.IP) (geo.Country, error) {
+	return geo.Country{Name: "Elbonia", ISO: "EB"}, nil
+}
+
+func (t *testDb) City(context.Context, net.IP) (geo.City, error) {
+	return geo.City{Name: "Bornyasherk"}, nil
+}
+
+func (t *testDb) ASN(context.Context, net.IP) (geo.ASN, error) {
+	return geo.ASN{AutonomousSystemNumber: 59795, AutonomousSystemOrganization: "Hosting4Real"}, nil
+}
+
+func (t *testDb) IsEmpty() bool { return false }
+
+func testClient(t *testing.T, server *http.Server, trustHeaders bool) pb.EchoIPClient {
+	client, _ := testServe(t, server, trustHeaders)
+	return client
+}
+
+func testServe(t *testing.T, server *http.Server, trustHeaders bool) (pb.EchoIPClient, *Server) {
+	s := New(server)
+	s.TrustHeaders = trustHeaders
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Serve(l) }()
+	conn, err := gogrpc.NewClient(l.Addr().String(), gogrpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		if err := s.Close(); err != nil {
+			t.Error(err)
+		}
+		if err := <-errCh; err != nil {
+			t.Errorf("want nil error after close, got %s", err)
+		}
+	})
+	if res, err := healthpb.NewHealthClient(conn).Check(t.Context(), &healthpb.HealthCheckRequest{Service: pb.EchoIP_ServiceDesc.ServiceName}); err != nil {
+		t.Fatal(err)
+	} else if res.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("want status %s, got %s", healthpb.HealthCheckResponse_SERVING, res.Status)
+	}
+	return pb.NewEchoIPClient(conn), s
+}
+
+func testServer() *http.Server {
+	s := http.New(&testDb{}, http.NewCache(100), false)
+	s.LookupPort = func(_ context.Context, _ net.IP, port uint64) error {
+		if port == 8001 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	s.PortAllowPrivate = true
+	s.PortDeny = []uint64{25}
+	return s
+}
+
+func TestLookup(t *testing.T) {
+	server := testServer()
+	server.IPHeaders = []string{"X-Forwarded-For"}
+	client := testClient(t, server, true)
+	ctx := t.Context()
+
+	response, err := client.WhoAmI(ctx, &pb.WhoAmIRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Ip != "127.0.0.1" || response.IpDecimal != "2130706433" || response.Country != "Elbonia" || response.Asn != "AS59795" {
+		t.Errorf("unexpected response: %v", response)
+	}
+	forwarded := metadata.AppendToOutgoingContext(ctx, "x-forwarded-for", "192.0.2.1, 127.0.0.1")
+	if response, err := client.WhoAmI(forwarded, &pb.WhoAmIRequest{}); err != nil {
+		t.Fatal(err)
+	} else if response.Ip != "192.0.2.1" {
+		t.Errorf("want IP from trusted header, got %s", response.Ip)
+	}
+	untrusted := testClient(t, server, false)
+	if response, err := untrusted.WhoAmI(forwarded, &pb.WhoAmIRequest{}); err != nil {
+		t.Fatal(err)
+	} else if response.Ip != "127.0.0.1" {
+		t.Errorf("want peer address when headers are not trusted, got %s", response.Ip)
+	}
+
+	if response, err := client.Lookup(ctx, &pb.LookupRequest{Ip: "2001:db8::1"}); err != nil {
+		t.Fatal(err)
+	} else if response.Ip != "2001:db8::1" || response.City != "Bornyasherk" {
+		t.Errorf("unexpected response: %v", response)
+	}
+	if _, err := client.Lookup(ctx, &pb.LookupRequest{Ip: "foo"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("want %s, got %v", codes.InvalidArgument, err)
+	}
+
+	stream, err := client.BatchLookup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips := []string{"192.0.2.1", "192.0.2.2", "2001:db8::1"}
+	for _, ip := range ips {
+		if err := stream.Send(&pb.LookupRequest{Ip: ip}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, response.Ip)
+	}
+	if len(got) != len(ips) {
+		t.Fatalf("want %d responses, got %d", len(ips), len(got))
+	}
+	for i := range ips {
+		if got[i] != ips[i] {
+			t.Errorf("#%d: want %s, got %s", i, ips[i], got[i])
+		}
+	}
+}
+
+func TestCheckPort(t *testing.T) {
+	client := testClient(t, testServer(), false)
+	var tests = []struct {
+		req       *pb.CheckPortRequest
+		reachable bool
+		code      codes.Code
+	}{
+		{&pb.CheckPortRequest{Port: 8000}, true, codes.OK},
+		{&pb.CheckPortRequest{Port: 8001}, false, codes.OK},
+		{&pb.CheckPortRequest{Port: 25}, false, codes.PermissionDenied},
+		{&pb.CheckPortRequest{Port: 0}, false, codes.InvalidArgument},
+		{&pb.CheckPortRequest{Port: 70000}, false, codes.InvalidArgument},
+		{&pb.CheckPortRequest{Port: 8000, Protocol: "sctp"}, false, codes.InvalidArgument},
+		{&pb.CheckPortRequest{Port: 8000, Probe: "foo"}, false, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		response, err := client.CheckPort(t.Context(), tt.req)
+		if code := status.Code(err); code != tt.code {
+			t.Errorf("%v: want %s, got %s (%v)", tt.req, tt.code, code, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if response.Ip != "127.0.0.1" || response.Port != tt.req.Port || response.Reachable != tt.reachable {
+			t.Errorf("%v: unexpected response: %v", tt.req, response)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	server := testServer()
+	server.RateLimits = map[string]http.RateLimit{"json": {Rate: 1.0 / 60, Burst: 2}}
+	client := testClient(t, server, false)
+	ctx := t.Context()
+
+	if _, err := client.WhoAmI(ctx, &pb.WhoAmIRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	// Each streamed request counts against the limit
+	stream, err := client.BatchLookup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		if err := stream.Send(&pb.LookupRequest{Ip: ip}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream.CloseSend()
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("want %s, got %v", codes.ResourceExhausted, err)
+	}
+	if _, err := client.Lookup(ctx, &pb.LookupRequest{Ip: "192.0.2.1"}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("want %s, got %v", codes.ResourceExhausted, err)
+	}
+	// Port checks are limited separately
+	if _, err := client.CheckPort(ctx, &pb.CheckPortRequest{Port: 8000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	server := testServer()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server.LookupPort = func(context.Context, net.IP, uint64) error {
+		close(started)
+		<-release
+		return nil
+	}
+	client, s := testServe(t, server, false)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := client.CheckPort(t.Context(), &pb.CheckPortRequest{Port: 8000})
+		errCh <- err
+	}()
+	<-started
+	shutdownCh := make(chan error, 1)
+	go func() { shutdownCh <- s.Shutdown(t.Context()) }()
+	select {
+	case err := <-shutdownCh:
+		t.Fatalf("shutdown returned before in-flight RPC completed: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-errCh; err != nil {
+		t.Errorf("want in-flight RPC to complete, got %s", err)
+	}
+	if err := <-shutdownCh; err != nil {
+		t.Error(err)
+	}
+}
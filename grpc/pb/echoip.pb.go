@@ -0,0 +1,658 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v5.29.3
+// source: echoip.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type WhoAmIRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WhoAmIRequest) Reset() {
+	*x = WhoAmIRequest{}
+	mi := &file_echoip_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WhoAmIRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WhoAmIRequest) ProtoMessage() {}
+
+func (x *WhoAmIRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_echoip_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WhoAmIRequest.ProtoReflect.Descriptor instead.
+func (*WhoAmIRequest) Descriptor() ([]byte, []int) {
+	return file_echoip_proto_rawDescGZIP(), []int{0}
+}
+
+type LookupRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ip            string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupRequest) Reset() {
+	*x = LookupRequest{}
+	mi := &file_echoip_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupRequest) ProtoMessage() {}
+
+func (x *LookupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_echoip_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupRequest.ProtoReflect.Descriptor instead.
+func (*LookupRequest) Descriptor() ([]byte, []int) {
+	return file_echoip_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *LookupRequest) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+// Response is equivalent to the JSON response of the HTTP API.
+type Response struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Ip               string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	IpDecimal        string                 `protobuf:"bytes,2,opt,name=ip_decimal,json=ipDecimal,proto3" json:"ip_decimal,omitempty"`
+	Country          string                 `protobuf:"bytes,3,opt,name=country,proto3" json:"country,omitempty"`
+	CountryIso       string                 `protobuf:"bytes,4,opt,name=country_iso,json=countryIso,proto3" json:"country_iso,omitempty"`
+	CountryEu        bool                   `protobuf:"varint,5,opt,name=country_eu,json=countryEu,proto3" json:"country_eu,omitempty"`
+	RegionName       string                 `protobuf:"bytes,6,opt,name=region_name,json=regionName,proto3" json:"region_name,omitempty"`
+	RegionCode       string                 `protobuf:"bytes,7,opt,name=region_code,json=regionCode,proto3" json:"region_code,omitempty"`
+	MetroCode        uint32                 `protobuf:"varint,8,opt,name=metro_code,json=metroCode,proto3" json:"metro_code,omitempty"`
+	ZipCode          string                 `protobuf:"bytes,9,opt,name=zip_code,json=zipCode,proto3" json:"zip_code,omitempty"`
+	City             string                 `protobuf:"bytes,10,opt,name=city,proto3" json:"city,omitempty"`
+	Latitude         float64                `protobuf:"fixed64,11,opt,name=latitude,proto3" json:"latitude,omitempty"`
+	Longitude        float64                `protobuf:"fixed64,12,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	TimeZone         string                 `protobuf:"bytes,13,opt,name=time_zone,json=timeZone,proto3" json:"time_zone,omitempty"`
+	Asn              string                 `protobuf:"bytes,14,opt,name=asn,proto3" json:"asn,omitempty"`
+	AsnOrg           string                 `protobuf:"bytes,15,opt,name=asn_org,json=asnOrg,proto3" json:"asn_org,omitempty"`
+	Network          string                 `protobuf:"bytes,16,opt,name=network,proto3" json:"network,omitempty"`
+	Hostname         string                 `protobuf:"bytes,17,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Hostnames        []string               `protobuf:"bytes,18,rep,name=hostnames,proto3" json:"hostnames,omitempty"`
+	HostnameVerified *bool                  `protobuf:"varint,19,opt,name=hostname_verified,json=hostnameVerified,proto3,oneof" json:"hostname_verified,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *Response) Reset() {
+	*x = Response{}
+	mi := &file_echoip_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Response) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Response) ProtoMessage() {}
+
+func (x *Response) ProtoReflect() protoreflect.Message {
+	mi := &file_echoip_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Response.ProtoReflect.Descriptor instead.
+func (*Response) Descriptor() ([]byte, []int) {
+	return file_echoip_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Response) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *Response) GetIpDecimal() string {
+	if x != nil {
+		return x.IpDecimal
+	}
+	return ""
+}
+
+func (x *Response) GetCountry() string {
+	if x != nil {
+		return x.Country
+	}
+	return ""
+}
+
+func (x *Response) GetCountryIso() string {
+	if x != nil {
+		return x.CountryIso
+	}
+	return ""
+}
+
+func (x *Response) GetCountryEu() bool {
+	if x != nil {
+		return x.CountryEu
+	}
+	return false
+}
+
+func (x *Response) GetRegionName() string {
+	if x != nil {
+		return x.RegionName
+	}
+	return ""
+}
+
+func (x *Response) GetRegionCode() string {
+	if x != nil {
+		return x.RegionCode
+	}
+	return ""
+}
+
+func (x *Response) GetMetroCode() uint32 {
+	if x != nil {
+		return x.MetroCode
+	}
+	return 0
+}
+
+func (x *Response) GetZipCode() string {
+	if x != nil {
+		return x.ZipCode
+	}
+	return ""
+}
+
+func (x *Response) GetCity() string {
+	if x != nil {
+		return x.City
+	}
+	return ""
+}
+
+func (x *Response) GetLatitude() float64 {
+	if x != nil {
+		return x.Latitude
+	}
+	return 0
+}
+
+func (x *Response) GetLongitude() float64 {
+	if x != nil {
+		return x.Longitude
+	}
+	return 0
+}
+
+func (x *Response) GetTimeZone() string {
+	if x != nil {
+		return x.TimeZone
+	}
+	return ""
+}
+
+func (x *Response) GetAsn() string {
+	if x != nil {
+		return x.Asn
+	}
+	return ""
+}
+
+func (x *Response) GetAsnOrg() string {
+	if x != nil {
+		return x.AsnOrg
+	}
+	return ""
+}
+
+func (x *Response) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+func (x *Response) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *Response) GetHostnames() []string {
+	if x != nil {
+		return x.Hostnames
+	}
+	return nil
+}
+
+func (x *Response) GetHostnameVerified() bool {
+	if x != nil && x.HostnameVerified != nil {
+		return *x.HostnameVerified
+	}
+	return false
+}
+
+type CheckPortRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Port  uint32                 `protobuf:"varint,1,opt,name=port,proto3" json:"port,omitempty"`
+	// Either "tcp" (the default) or "udp".
+	Protocol string `protobuf:"bytes,2,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	// Optional probe: "tls" or "banner" for TCP, "dns" or "wireguard" for UDP.
+	Probe         string `protobuf:"bytes,3,opt,name=probe,proto3" json:"probe,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckPortRequest) Reset() {
+	*x = CheckPortRequest{}
+	mi := &file_echoip_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckPortRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckPortRequest) ProtoMessage() {}
+
+func (x *CheckPortRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_echoip_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckPortRequest.ProtoReflect.Descriptor instead.
+func (*CheckPortRequest) Descriptor() ([]byte, []int) {
+	return file_echoip_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CheckPortRequest) GetPort() uint32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *CheckPortRequest) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *CheckPortRequest) GetProbe() string {
+	if x != nil {
+		return x.Probe
+	}
+	return ""
+}
+
+// PortResponse is equivalent to the JSON response of the /port endpoint.
+type PortResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ip            string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	Port          uint32                 `protobuf:"varint,2,opt,name=port,proto3" json:"port,omitempty"`
+	Protocol      string                 `protobuf:"bytes,3,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	Reachable     bool                   `protobuf:"varint,4,opt,name=reachable,proto3" json:"reachable,omitempty"`
+	State         string                 `protobuf:"bytes,5,opt,name=state,proto3" json:"state,omitempty"`
+	Tls           *PortTLS               `protobuf:"bytes,6,opt,name=tls,proto3" json:"tls,omitempty"`
+	Banner        string                 `protobuf:"bytes,7,opt,name=banner,proto3" json:"banner,omitempty"`
+	ProbeError    string                 `protobuf:"bytes,8,opt,name=probe_error,json=probeError,proto3" json:"probe_error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PortResponse) Reset() {
+	*x = PortResponse{}
+	mi := &file_echoip_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PortResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PortResponse) ProtoMessage() {}
+
+func (x *PortResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_echoip_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PortResponse.ProtoReflect.Descriptor instead.
+func (*PortResponse) Descriptor() ([]byte, []int) {
+	return file_echoip_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *PortResponse) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *PortResponse) GetPort() uint32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *PortResponse) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *PortResponse) GetReachable() bool {
+	if x != nil {
+		return x.Reachable
+	}
+	return false
+}
+
+func (x *PortResponse) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *PortResponse) GetTls() *PortTLS {
+	if x != nil {
+		return x.Tls
+	}
+	return nil
+}
+
+func (x *PortResponse) GetBanner() string {
+	if x != nil {
+		return x.Banner
+	}
+	return ""
+}
+
+func (x *PortResponse) GetProbeError() string {
+	if x != nil {
+		return x.ProbeError
+	}
+	return ""
+}
+
+type PortTLS struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	Alpn          string                 `protobuf:"bytes,2,opt,name=alpn,proto3" json:"alpn,omitempty"`
+	Subject       string                 `protobuf:"bytes,3,opt,name=subject,proto3" json:"subject,omitempty"`
+	Sans          []string               `protobuf:"bytes,4,rep,name=sans,proto3" json:"sans,omitempty"`
+	NotAfter      *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PortTLS) Reset() {
+	*x = PortTLS{}
+	mi := &file_echoip_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PortTLS) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PortTLS) ProtoMessage() {}
+
+func (x *PortTLS) ProtoReflect() protoreflect.Message {
+	mi := &file_echoip_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PortTLS.ProtoReflect.Descriptor instead.
+func (*PortTLS) Descriptor() ([]byte, []int) {
+	return file_echoip_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PortTLS) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *PortTLS) GetAlpn() string {
+	if x != nil {
+		return x.Alpn
+	}
+	return ""
+}
+
+func (x *PortTLS) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+func (x *PortTLS) GetSans() []string {
+	if x != nil {
+		return x.Sans
+	}
+	return nil
+}
+
+func (x *PortTLS) GetNotAfter() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NotAfter
+	}
+	return nil
+}
+
+var File_echoip_proto protoreflect.FileDescriptor
+
+const file_echoip_proto_rawDesc = "" +
+	"\n" +
+	"\fechoip.proto\x12\techoip.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x0f\n" +
+	"\rWhoAmIRequest\"\x1f\n" +
+	"\rLookupRequest\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\"\xc1\x04\n" +
+	"\bResponse\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\x12\x1d\n" +
+	"\n" +
+	"ip_decimal\x18\x02 \x01(\tR\tipDecimal\x12\x18\n" +
+	"\acountry\x18\x03 \x01(\tR\acountry\x12\x1f\n" +
+	"\vcountry_iso\x18\x04 \x01(\tR\n" +
+	"countryIso\x12\x1d\n" +
+	"\n" +
+	"country_eu\x18\x05 \x01(\bR\tcountryEu\x12\x1f\n" +
+	"\vregion_name\x18\x06 \x01(\tR\n" +
+	"regionName\x12\x1f\n" +
+	"\vregion_code\x18\a \x01(\tR\n" +
+	"regionCode\x12\x1d\n" +
+	"\n" +
+	"metro_code\x18\b \x01(\rR\tmetroCode\x12\x19\n" +
+	"\bzip_code\x18\t \x01(\tR\azipCode\x12\x12\n" +
+	"\x04city\x18\n" +
+	" \x01(\tR\x04city\x12\x1a\n" +
+	"\blatitude\x18\v \x01(\x01R\blatitude\x12\x1c\n" +
+	"\tlongitude\x18\f \x01(\x01R\tlongitude\x12\x1b\n" +
+	"\ttime_zone\x18\r \x01(\tR\btimeZone\x12\x10\n" +
+	"\x03asn\x18\x0e \x01(\tR\x03asn\x12\x17\n" +
+	"\aasn_org\x18\x0f \x01(\tR\x06asnOrg\x12\x18\n" +
+	"\anetwork\x18\x10 \x01(\tR\anetwork\x12\x1a\n" +
+	"\bhostname\x18\x11 \x01(\tR\bhostname\x12\x1c\n" +
+	"\thostnames\x18\x12 \x03(\tR\thostnames\x120\n" +
+	"\x11hostname_verified\x18\x13 \x01(\bH\x00R\x10hostnameVerified\x88\x01\x01B\x14\n" +
+	"\x12_hostname_verified\"X\n" +
+	"\x10CheckPortRequest\x12\x12\n" +
+	"\x04port\x18\x01 \x01(\rR\x04port\x12\x1a\n" +
+	"\bprotocol\x18\x02 \x01(\tR\bprotocol\x12\x14\n" +
+	"\x05probe\x18\x03 \x01(\tR\x05probe\"\xe1\x01\n" +
+	"\fPortResponse\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\x12\x12\n" +
+	"\x04port\x18\x02 \x01(\rR\x04port\x12\x1a\n" +
+	"\bprotocol\x18\x03 \x01(\tR\bprotocol\x12\x1c\n" +
+	"\treachable\x18\x04 \x01(\bR\treachable\x12\x14\n" +
+	"\x05state\x18\x05 \x01(\tR\x05state\x12$\n" +
+	"\x03tls\x18\x06 \x01(\v2\x12.echoip.v1.PortTLSR\x03tls\x12\x16\n" +
+	"\x06banner\x18\a \x01(\tR\x06banner\x12\x1f\n" +
+	"\vprobe_error\x18\b \x01(\tR\n" +
+	"probeError\"\x9e\x01\n" +
+	"\aPortTLS\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12\x12\n" +
+	"\x04alpn\x18\x02 \x01(\tR\x04alpn\x12\x18\n" +
+	"\asubject\x18\x03 \x01(\tR\asubject\x12\x12\n" +
+	"\x04sans\x18\x04 \x03(\tR\x04sans\x127\n" +
+	"\tnot_after\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\bnotAfter2\xff\x01\n" +
+	"\x06EchoIP\x127\n" +
+	"\x06WhoAmI\x12\x18.echoip.v1.WhoAmIRequest\x1a\x13.echoip.v1.Response\x127\n" +
+	"\x06Lookup\x12\x18.echoip.v1.LookupRequest\x1a\x13.echoip.v1.Response\x12@\n" +
+	"\vBatchLookup\x12\x18.echoip.v1.LookupRequest\x1a\x13.echoip.v1.Response(\x010\x01\x12A\n" +
+	"\tCheckPort\x12\x1b.echoip.v1.CheckPortRequest\x1a\x17.echoip.v1.PortResponseB#Z!github.com/mpolden/echoip/grpc/pbb\x06proto3"
+
+var (
+	file_echoip_proto_rawDescOnce sync.Once
+	file_echoip_proto_rawDescData []byte
+)
+
+func file_echoip_proto_rawDescGZIP() []byte {
+	file_echoip_proto_rawDescOnce.Do(func() {
+		file_echoip_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_echoip_proto_rawDesc), len(file_echoip_proto_rawDesc)))
+	})
+	return file_echoip_proto_rawDescData
+}
+
+var file_echoip_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_echoip_proto_goTypes = []any{
+	(*WhoAmIRequest)(nil),         // 0: echoip.v1.WhoAmIRequest
+	(*LookupRequest)(nil),         // 1: echoip.v1.LookupRequest
+	(*Response)(nil),              // 2: echoip.v1.Response
+	(*CheckPortRequest)(nil),      // 3: echoip.v1.CheckPortRequest
+	(*PortResponse)(nil),          // 4: echoip.v1.PortResponse
+	(*PortTLS)(nil),               // 5: echoip.v1.PortTLS
+	(*timestamppb.Timestamp)(nil), // 6: google.protobuf.Timestamp
+}
+var file_echoip_proto_depIdxs = []int32{
+	5, // 0: echoip.v1.PortResponse.tls:type_name -> echoip.v1.PortTLS
+	6, // 1: echoip.v1.PortTLS.not_after:type_name -> google.protobuf.Timestamp
+	0, // 2: echoip.v1.EchoIP.WhoAmI:input_type -> echoip.v1.WhoAmIRequest
+	1, // 3: echoip.v1.EchoIP.Lookup:input_type -> echoip.v1.LookupRequest
+	1, // 4: echoip.v1.EchoIP.BatchLookup:input_type -> echoip.v1.LookupRequest
+	3, // 5: echoip.v1.EchoIP.CheckPort:input_type -> echoip.v1.CheckPortRequest
+	2, // 6: echoip.v1.EchoIP.WhoAmI:output_type -> echoip.v1.Response
+	2, // 7: echoip.v1.EchoIP.Lookup:output_type -> echoip.v1.Response
+	2, // 8: echoip.v1.EchoIP.BatchLookup:output_type -> echoip.v1.Response
+	4, // 9: echoip.v1.EchoIP.CheckPort:output_type -> echoip.v1.PortResponse
+	6, // [6:10] is the sub-list for method output_type
+	2, // [2:6] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_echoip_proto_init() }
+func file_echoip_proto_init() {
+	if File_echoip_proto != nil {
+		return
+	}
+	file_echoip_proto_msgTypes[2].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_echoip_proto_rawDesc), len(file_echoip_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_echoip_proto_goTypes,
+		DependencyIndexes: file_echoip_proto_depIdxs,
+		MessageInfos:      file_echoip_proto_msgTypes,
+	}.Build()
+	File_echoip_proto = out.File
+	file_echoip_proto_goTypes = nil
+	file_echoip_proto_depIdxs = nil
+}
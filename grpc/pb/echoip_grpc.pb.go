@@ -0,0 +1,246 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: echoip.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	EchoIP_WhoAmI_FullMethodName      = "/echoip.v1.EchoIP/WhoAmI"
+	EchoIP_Lookup_FullMethodName      = "/echoip.v1.EchoIP/Lookup"
+	EchoIP_BatchLookup_FullMethodName = "/echoip.v1.EchoIP/BatchLookup"
+	EchoIP_CheckPort_FullMethodName   = "/echoip.v1.EchoIP/CheckPort"
+)
+
+// EchoIPClient is the client API for EchoIP service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// EchoIP looks up information about IP addresses, mirroring the HTTP API.
+type EchoIPClient interface {
+	// WhoAmI returns information about the address of the caller.
+	WhoAmI(ctx context.Context, in *WhoAmIRequest, opts ...grpc.CallOption) (*Response, error)
+	// Lookup returns information about the given address.
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*Response, error)
+	// BatchLookup returns information about each address sent on the stream,
+	// in the order they are received.
+	BatchLookup(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[LookupRequest, Response], error)
+	// CheckPort checks whether a port is reachable on the address of the
+	// caller.
+	CheckPort(ctx context.Context, in *CheckPortRequest, opts ...grpc.CallOption) (*PortResponse, error)
+}
+
+type echoIPClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEchoIPClient(cc grpc.ClientConnInterface) EchoIPClient {
+	return &echoIPClient{cc}
+}
+
+func (c *echoIPClient) WhoAmI(ctx context.Context, in *WhoAmIRequest, opts ...grpc.CallOption) (*Response, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Response)
+	err := c.cc.Invoke(ctx, EchoIP_WhoAmI_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoIPClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*Response, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Response)
+	err := c.cc.Invoke(ctx, EchoIP_Lookup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoIPClient) BatchLookup(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[LookupRequest, Response], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &EchoIP_ServiceDesc.Streams[0], EchoIP_BatchLookup_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[LookupRequest, Response]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type EchoIP_BatchLookupClient = grpc.BidiStreamingClient[LookupRequest, Response]
+
+func (c *echoIPClient) CheckPort(ctx context.Context, in *CheckPortRequest, opts ...grpc.CallOption) (*PortResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PortResponse)
+	err := c.cc.Invoke(ctx, EchoIP_CheckPort_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EchoIPServer is the server API for EchoIP service.
+// All implementations must embed UnimplementedEchoIPServer
+// for forward compatibility.
+//
+// EchoIP looks up information about IP addresses, mirroring the HTTP API.
+type EchoIPServer interface {
+	// WhoAmI returns information about the address of the caller.
+	WhoAmI(context.Context, *WhoAmIRequest) (*Response, error)
+	// Lookup returns information about the given address.
+	Lookup(context.Context, *LookupRequest) (*Response, error)
+	// BatchLookup returns information about each address sent on the stream,
+	// in the order they are received.
+	BatchLookup(grpc.BidiStreamingServer[LookupRequest, Response]) error
+	// CheckPort checks whether a port is reachable on the address of the
+	// caller.
+	CheckPort(context.Context, *CheckPortRequest) (*PortResponse, error)
+	mustEmbedUnimplementedEchoIPServer()
+}
+
+// UnimplementedEchoIPServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedEchoIPServer struct{}
+
+func (UnimplementedEchoIPServer) WhoAmI(context.Context, *WhoAmIRequest) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WhoAmI not implemented")
+}
+func (UnimplementedEchoIPServer) Lookup(context.Context, *LookupRequest) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedEchoIPServer) BatchLookup(grpc.BidiStreamingServer[LookupRequest, Response]) error {
+	return status.Errorf(codes.Unimplemented, "method BatchLookup not implemented")
+}
+func (UnimplementedEchoIPServer) CheckPort(context.Context, *CheckPortRequest) (*PortResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckPort not implemented")
+}
+func (UnimplementedEchoIPServer) mustEmbedUnimplementedEchoIPServer() {}
+func (UnimplementedEchoIPServer) testEmbeddedByValue()                {}
+
+// UnsafeEchoIPServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EchoIPServer will
+// result in compilation errors.
+type UnsafeEchoIPServer interface {
+	mustEmbedUnimplementedEchoIPServer()
+}
+
+func RegisterEchoIPServer(s grpc.ServiceRegistrar, srv EchoIPServer) {
+	// If the following call pancis, it indicates UnimplementedEchoIPServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&EchoIP_ServiceDesc, srv)
+}
+
+func _EchoIP_WhoAmI_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WhoAmIRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoIPServer).WhoAmI(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EchoIP_WhoAmI_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoIPServer).WhoAmI(ctx, req.(*WhoAmIRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EchoIP_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoIPServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EchoIP_Lookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoIPServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EchoIP_BatchLookup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoIPServer).BatchLookup(&grpc.GenericServerStream[LookupRequest, Response]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type EchoIP_BatchLookupServer = grpc.BidiStreamingServer[LookupRequest, Response]
+
+func _EchoIP_CheckPort_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckPortRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoIPServer).CheckPort(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EchoIP_CheckPort_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoIPServer).CheckPort(ctx, req.(*CheckPortRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// EchoIP_ServiceDesc is the grpc.ServiceDesc for EchoIP service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var EchoIP_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "echoip.v1.EchoIP",
+	HandlerType: (*EchoIPServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "WhoAmI",
+			Handler:    _EchoIP_WhoAmI_Handler,
+		},
+		{
+			MethodName: "Lookup",
+			Handler:    _EchoIP_Lookup_Handler,
+		},
+		{
+			MethodName: "CheckPort",
+			Handler:    _EchoIP_CheckPort_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "BatchLookup",
+			Handler:       _EchoIP_BatchLookup_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "echoip.proto",
+}
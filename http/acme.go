@@ -0,0 +1,68 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig configures obtaining certificates using ACME.
+type ACMEConfig struct {
+	// Hosts are the host names to obtain certificates for.
+	Hosts []string
+	// Email is the contact email address of the ACME account.
+	Email string
+	// Directory is the URL of the ACME directory. Defaults to Let's Encrypt.
+	Directory string
+	// CacheDir is the directory for storing account keys and certificates.
+	// Defaults to memory.
+	CacheDir string
+	// RootCAs are the certificate authorities trusted when connecting to the
+	// ACME server, e.g. the CA of a local Pebble instance. Defaults to the
+	// system roots.
+	RootCAs *x509.CertPool
+}
+
+// NewACMEManager creates a manager obtaining certificates as configured by
+// config. TLS-ALPN-01 challenges are answered by the TLS configuration of the
+// manager, and HTTP-01 challenges by its HTTPHandler.
+func NewACMEManager(config ACMEConfig) *autocert.Manager {
+	client := &acme.Client{DirectoryURL: config.Directory}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+	if config.RootCAs != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: config.RootCAs}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(config.Hosts...),
+		Email:      config.Email,
+		Client:     client,
+	}
+	if config.CacheDir != "" {
+		m.Cache = autocert.DirCache(config.CacheDir)
+	}
+	return m
+}
+
+// LoadCertPool creates a certificate pool from the PEM-encoded certificates in
+// filename.
+func LoadCertPool(filename string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %s", filename)
+	}
+	return pool, nil
+}
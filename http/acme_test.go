@@ -0,0 +1,83 @@
+package http
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// acmeDirectory returns a TLS server serving a stub ACME directory.
+func acmeDirectory(t *testing.T) *httptest.Server {
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/directory" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   srv.URL + "/nonce",
+			"newAccount": srv.URL + "/account",
+			"newOrder":   srv.URL + "/order",
+			"revokeCert": srv.URL + "/revoke",
+			"keyChange":  srv.URL + "/key-change",
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestACMEManager(t *testing.T) {
+	srv := acmeDirectory(t)
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	roots, err := LoadCertPool(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewACMEManager(ACMEConfig{
+		Hosts:     []string{"example.com"},
+		Email:     "admin@example.com",
+		Directory: srv.URL + "/directory",
+		CacheDir:  dir,
+		RootCAs:   roots,
+	})
+	directory, err := m.Client.Discover(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := srv.URL + "/order"; directory.OrderURL != want {
+		t.Errorf("want order URL %s, got %s", want, directory.OrderURL)
+	}
+	if err := m.HostPolicy(t.Context(), "example.com"); err != nil {
+		t.Errorf("want example.com to be allowed, got %s", err)
+	}
+	if err := m.HostPolicy(t.Context(), "example.org"); err == nil {
+		t.Error("want example.org to be rejected")
+	}
+
+	// The directory is not trusted without its CA
+	m = NewACMEManager(ACMEConfig{Hosts: []string{"example.com"}, Directory: srv.URL + "/directory"})
+	if _, err := m.Client.Discover(t.Context()); err == nil {
+		t.Error("want error when the CA of the directory is not trusted")
+	}
+
+	if _, err := LoadCertPool(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("want error for missing file")
+	}
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCertPool(empty); err == nil {
+		t.Error("want error for file without certificates")
+	}
+}
@@ -0,0 +1,76 @@
+package http
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// lookupGroup ensures that concurrent lookups of the same IP address are only
+// performed once. Callers arriving while a lookup is in flight wait for it and
+// share its result.
+type lookupGroup struct {
+	mu        sync.Mutex
+	calls     map[uint64]*lookupCall
+	lookups   uint64
+	coalesced uint64
+}
+
+type lookupCall struct {
+	done     chan struct{}
+	response Response
+}
+
+type LookupStats struct {
+	InFlight  int
+	Lookups   uint64
+	Coalesced uint64
+}
+
+// Do runs fn for the given IP, unless a call for the same IP is already in
+// flight. The call itself is not tied to ctx, so a caller giving up does not
+// affect other callers waiting for the same result. Do returns early with the
+// context error if ctx is done before the result is available.
+func (g *lookupGroup) Do(ctx context.Context, ip net.IP, fn func() Response) (Response, bool, error) {
+	k := key(ip)
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[uint64]*lookupCall)
+	}
+	c, shared := g.calls[k]
+	if shared {
+		g.coalesced++
+	} else {
+		c = &lookupCall{done: make(chan struct{})}
+		g.calls[k] = c
+		g.lookups++
+		go g.call(k, c, fn)
+	}
+	g.mu.Unlock()
+	select {
+	case <-c.done:
+		return c.response, shared, nil
+	case <-ctx.Done():
+		return Response{}, shared, ctx.Err()
+	}
+}
+
+func (g *lookupGroup) call(k uint64, c *lookupCall, fn func() Response) {
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, k)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.response = fn()
+}
+
+func (g *lookupGroup) Stats() LookupStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return LookupStats{
+		InFlight:  len(g.calls),
+		Lookups:   g.lookups,
+		Coalesced: g.coalesced,
+	}
+}
@@ -0,0 +1,114 @@
+package http
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestLookupGroupCoalesce(t *testing.T) {
+	var g lookupGroup
+	ip := net.ParseIP("192.0.2.1")
+	n := 10
+	calls := 0
+	started := make(chan bool)
+	release := make(chan bool)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var shared int
+	lookup := func() {
+		defer wg.Done()
+		r, ok, err := g.Do(context.Background(), ip, func() Response {
+			calls++
+			started <- true
+			<-release
+			return Response{IP: ip}
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		if !r.IP.Equal(ip) {
+			t.Errorf("got IP %s, want %s", r.IP, ip)
+		}
+		if ok {
+			mu.Lock()
+			shared++
+			mu.Unlock()
+		}
+	}
+	wg.Add(1)
+	go lookup()
+	<-started
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go lookup()
+	}
+	// Wait for all callers to join the in-flight lookup
+	for g.Stats().Coalesced < uint64(n-1) {
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("got %d calls, want %d", calls, 1)
+	}
+	if shared != n-1 {
+		t.Errorf("got %d shared results, want %d", shared, n-1)
+	}
+	stats := g.Stats()
+	if got, want := stats.Lookups, uint64(1); got != want {
+		t.Errorf("got %d lookups, want %d", got, want)
+	}
+	if got, want := stats.InFlight, 0; got != want {
+		t.Errorf("got %d in flight, want %d", got, want)
+	}
+}
+
+func TestLookupGroupSequential(t *testing.T) {
+	var g lookupGroup
+	ip := net.ParseIP("192.0.2.1")
+	calls := 0
+	for i := 0; i < 3; i++ {
+		g.Do(context.Background(), ip, func() Response {
+			calls++
+			return Response{IP: ip}
+		})
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls, want %d", calls, 3)
+	}
+	if got, want := g.Stats().Coalesced, uint64(0); got != want {
+		t.Errorf("got %d coalesced, want %d", got, want)
+	}
+}
+
+func TestLookupGroupCancel(t *testing.T) {
+	var g lookupGroup
+	ip := net.ParseIP("192.0.2.1")
+	release := make(chan bool)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := g.Do(ctx, ip, func() Response {
+		<-release
+		return Response{IP: ip}
+	})
+	if err != context.Canceled {
+		t.Errorf("got err %v, want %v", err, context.Canceled)
+	}
+	// A caller with a live context still receives the result of the
+	// abandoned call
+	done := make(chan Response)
+	go func() {
+		r, _, _ := g.Do(context.Background(), ip, func() Response { return Response{} })
+		done <- r
+	}()
+	for g.Stats().Coalesced < 1 {
+		runtime.Gosched()
+	}
+	close(release)
+	if r := <-done; !r.IP.Equal(ip) {
+		t.Errorf("got IP %s, want %s", r.IP, ip)
+	}
+}
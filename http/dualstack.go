@@ -0,0 +1,160 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lifetime of a dual-stack token
+const dualStackTTL = time.Minute
+
+// DualStackResponse contains the IPv4 and IPv6 addresses seen for a token.
+type DualStackResponse struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+	IPv4    net.IP    `json:"ipv4,omitempty"`
+	IPv6    net.IP    `json:"ipv6,omitempty"`
+	IPv4URL string    `json:"ipv4_url"`
+	IPv6URL string    `json:"ipv6_url"`
+}
+
+// dualStack issues short-lived tokens carrying the addresses seen so far, so
+// that requests made by the same client over IPv4 and IPv6 can be correlated.
+// Tokens are signed, so any instance sharing the key can verify them.
+type dualStack struct {
+	once sync.Once
+	key  []byte
+	now  func() time.Time
+}
+
+type dualStackResult struct {
+	ipv4    net.IP
+	ipv6    net.IP
+	expires time.Time
+}
+
+func (d *dualStack) init(key []byte) {
+	d.once.Do(func() {
+		d.key = key
+		if len(d.key) == 0 {
+			d.key = make([]byte, 32)
+			rand.Read(d.key)
+		}
+		if d.now == nil {
+			d.now = time.Now
+		}
+	})
+}
+
+func (d *dualStack) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// token returns a token carrying the expiry time and addresses of result,
+// signed with the key. Missing addresses are encoded as zeroes.
+func (d *dualStack) token(result dualStackResult) string {
+	payload := binary.BigEndian.AppendUint64(nil, uint64(result.expires.Unix()))
+	ipv4, ipv6 := make(net.IP, net.IPv4len), make(net.IP, net.IPv6len)
+	copy(ipv4, result.ipv4.To4())
+	copy(ipv6, result.ipv6.To16())
+	payload = append(payload, ipv4...)
+	payload = append(payload, ipv6...)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(d.sign(payload))
+}
+
+// newResult returns an empty result expiring after dualStackTTL.
+func (d *dualStack) newResult() dualStackResult {
+	return dualStackResult{expires: d.now().Add(dualStackTTL).Truncate(time.Second)}
+}
+
+// verify checks the signature and expiry of token, returning the result it
+// carries.
+func (d *dualStack) verify(token string) (dualStackResult, error) {
+	p, s, ok := strings.Cut(token, ".")
+	enc := base64.RawURLEncoding
+	payload, err1 := enc.DecodeString(p)
+	sig, err2 := enc.DecodeString(s)
+	if !ok || err1 != nil || err2 != nil || len(payload) != 8+net.IPv4len+net.IPv6len || !hmac.Equal(sig, d.sign(payload)) {
+		return dualStackResult{}, errors.New("invalid token")
+	}
+	result := dualStackResult{expires: time.Unix(int64(binary.BigEndian.Uint64(payload[:8])), 0)}
+	if !d.now().Before(result.expires) {
+		return dualStackResult{}, errors.New("token has expired")
+	}
+	if ipv4 := net.IP(payload[8:12]); !ipv4.IsUnspecified() {
+		result.ipv4 = ipv4
+	}
+	if ipv6 := net.IP(payload[12:]); !ipv6.IsUnspecified() {
+		result.ipv6 = ipv6
+	}
+	return result, nil
+}
+
+func (s *Server) dualStackEnabled() bool {
+	return s.DualStackIPv4Host != "" && s.DualStackIPv6Host != ""
+}
+
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https"
+	}
+	return "http"
+}
+
+// DualStackHandler records the address of the client in the token given in
+// the query string, or in a new token if none is given. The response includes
+// the updated token, URLs on the IPv4-only and IPv6-only hosts to which it
+// should be sent, and the addresses recorded so far.
+func (s *Server) DualStackHandler(w http.ResponseWriter, r *http.Request) *appError {
+	s.dualStack.init(s.DualStackKey)
+	ip, err := ipFromRequest(s.IPHeaders, r, false)
+	if err != nil {
+		return badRequest(err).WithMessage(err.Error()).AsJSON()
+	}
+	result := s.dualStack.newResult()
+	if token := r.URL.Query().Get("token"); token != "" {
+		if result, err = s.dualStack.verify(token); err != nil {
+			return badRequest(err).WithMessage(err.Error()).AsJSON()
+		}
+	}
+	if ip.To4() != nil {
+		result.ipv4 = ip
+	} else {
+		result.ipv6 = ip
+	}
+	token := s.dualStack.token(result)
+	query := "/dualstack?token=" + url.QueryEscape(token)
+	scheme := requestScheme(r)
+	response := DualStackResponse{
+		Token:   token,
+		Expires: result.expires.UTC(),
+		IPv4:    result.ipv4,
+		IPv6:    result.ipv6,
+		IPv4URL: scheme + "://" + s.DualStackIPv4Host + query,
+		IPv6URL: scheme + "://" + s.DualStackIPv6Host + query,
+	}
+	b, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return internalServerError(err).AsJSON()
+	}
+	// The response is requested by pages on other hosts
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", jsonMediaType)
+	w.Write(b)
+	return nil
+}
@@ -0,0 +1,162 @@
+package http
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDualStackToken(t *testing.T) {
+	now := time.Now()
+	d := dualStack{now: func() time.Time { return now }}
+	d.init([]byte("secret"))
+	var results = []dualStackResult{
+		d.newResult(),
+		{ipv4: net.ParseIP("192.0.2.1").To4(), expires: now.Add(time.Second).Truncate(time.Second)},
+		{ipv4: net.ParseIP("192.0.2.1").To4(), ipv6: net.ParseIP("2001:db8::1"), expires: now.Add(dualStackTTL).Truncate(time.Second)},
+	}
+	for _, result := range results {
+		token := d.token(result)
+		if got, err := d.verify(token); err != nil || !reflect.DeepEqual(got, result) {
+			t.Errorf("verify(%q) = (%+v, %v), want (%+v, nil)", token, got, err, result)
+		}
+	}
+
+	token := d.token(results[2])
+	tampered := "A" + token[1:]
+	if tampered == token {
+		tampered = "B" + token[1:]
+	}
+	other := dualStack{now: d.now}
+	other.init([]byte("other secret"))
+	var tests = []struct {
+		d     *dualStack
+		token string
+	}{
+		{&other, token},
+		{&d, token[:len(token)-1]},
+		{&d, tampered},
+		{&d, "foo"},
+		{&d, ""},
+	}
+	for _, tt := range tests {
+		if _, err := tt.d.verify(tt.token); err == nil {
+			t.Errorf("verify(%q): expected error", tt.token)
+		}
+	}
+
+	now = now.Add(dualStackTTL)
+	if _, err := d.verify(token); err == nil {
+		t.Errorf("verify(%q): expected error for expired token", token)
+	}
+}
+
+func TestDualStackHandler(t *testing.T) {
+	s := testServer()
+	s.IPHeaders = []string{"X-Real-IP"}
+	s.DualStackIPv4Host = "v4.example.com"
+	s.DualStackIPv6Host = "v6.example.com:8080"
+	s.DualStackKey = []byte("secret")
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	get := func(url, ip string) (DualStackResponse, *http.Response) {
+		r, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("X-Real-IP", ip)
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var response DualStackResponse
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return response, res
+	}
+
+	first, res := get(srv.URL+"/dualstack", "192.0.2.1")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("want status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if got := res.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("want Access-Control-Allow-Origin %q, got %q", "*", got)
+	}
+	if !first.IPv4.Equal(net.ParseIP("192.0.2.1")) || first.IPv6 != nil {
+		t.Errorf("want only IPv4 address, got %s and %s", first.IPv4, first.IPv6)
+	}
+	if want := "http://v4.example.com/dualstack?token=" + first.Token; first.IPv4URL != want {
+		t.Errorf("want %q, got %q", want, first.IPv4URL)
+	}
+	if want := "http://v6.example.com:8080/dualstack?token=" + first.Token; first.IPv6URL != want {
+		t.Errorf("want %q, got %q", want, first.IPv6URL)
+	}
+
+	// Any instance sharing the key completes the detection
+	other := testServer()
+	other.IPHeaders = s.IPHeaders
+	other.DualStackIPv4Host = s.DualStackIPv4Host
+	other.DualStackIPv6Host = s.DualStackIPv6Host
+	other.DualStackKey = s.DualStackKey
+	otherSrv := httptest.NewServer(other.Handler())
+	defer otherSrv.Close()
+	second, _ := get(otherSrv.URL+"/dualstack?token="+first.Token, "2001:db8::1")
+	if !second.IPv4.Equal(net.ParseIP("192.0.2.1")) || !second.IPv6.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("want both addresses, got %s and %s", second.IPv4, second.IPv6)
+	}
+	if !second.Expires.Equal(first.Expires) {
+		t.Errorf("want token expiring at %s, got %s", first.Expires, second.Expires)
+	}
+	if want := "http://v4.example.com/dualstack?token=" + second.Token; second.IPv4URL != want {
+		t.Errorf("want %q, got %q", want, second.IPv4URL)
+	}
+
+	// A later request over the same family replaces its address
+	third, _ := get(srv.URL+"/dualstack?token="+second.Token, "192.0.2.2")
+	if !third.IPv4.Equal(net.ParseIP("192.0.2.2")) || !third.IPv6.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("want updated IPv4 address, got %s and %s", third.IPv4, third.IPv6)
+	}
+
+	if _, res := get(srv.URL+"/dualstack?token=invalid", "192.0.2.1"); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("want status %d for invalid token, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+}
+
+func TestDualStackTemplate(t *testing.T) {
+	s := testServer()
+	s.Template = "../html"
+	s.DualStackIPv4Host = "v4.example.com"
+	s.DualStackIPv6Host = "v6.example.com"
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	out, status, err := httpGet(srv.URL, "", "Mozilla/5.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK {
+		t.Fatalf("want status %d, got %d", http.StatusOK, status)
+	}
+	if !strings.Contains(out, `id="dualstack-ipv6"`) {
+		t.Error("expected dual-stack section in page")
+	}
+
+	s.DualStackIPv6Host = ""
+	srv = httptest.NewServer(s.Handler())
+	defer srv.Close()
+	if out, _, err = httpGet(srv.URL, "", "Mozilla/5.0"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, `id="dualstack-ipv6"`) {
+		t.Error("expected no dual-stack section in page")
+	}
+}
@@ -25,6 +25,14 @@ func badRequest(err error) *appError {
 	return &appError{Error: err, Code: http.StatusBadRequest}
 }
 
+func forbidden(err error) *appError {
+	return &appError{Error: err, Code: http.StatusForbidden}
+}
+
+func tooManyRequests(err error) *appError {
+	return &appError{Error: err, Code: http.StatusTooManyRequests}
+}
+
 func (e *appError) AsJSON() *appError {
 	e.ContentType = jsonMediaType
 	return e
@@ -0,0 +1,389 @@
+package http
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// Maximum number of bytes buffered while waiting for a complete ClientHello
+const maxClientHelloSize = 1 << 16
+
+// TLS extensions
+const (
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extPointFormats        = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+)
+
+var errInvalidClientHello = errors.New("invalid ClientHello")
+
+// TLSFingerprint describes the ClientHello a TLS connection was opened with.
+type TLSFingerprint struct {
+	JA3             string   `json:"ja3"`
+	JA3Hash         string   `json:"ja3_hash"`
+	JA4             string   `json:"ja4"`
+	CipherSuites    []string `json:"cipher_suites"`
+	Extensions      []uint16 `json:"extensions"`
+	SupportedGroups []string `json:"supported_groups,omitempty"`
+	ALPN            []string `json:"alpn,omitempty"`
+}
+
+type clientHelloKey struct{}
+
+// clientHello holds the fields of a ClientHello message used for
+// fingerprinting.
+type clientHello struct {
+	version             uint16
+	cipherSuites        []uint16
+	extensions          []uint16
+	serverName          bool
+	supportedGroups     []uint16
+	pointFormats        []uint8
+	signatureAlgorithms []uint16
+	alpn                []string
+	supportedVersions   []uint16
+}
+
+// helloListener wraps the connections accepted by a TLS listener in helloConn.
+type helloListener struct{ net.Listener }
+
+func (l helloListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &helloConn{Conn: c}, nil
+}
+
+// helloConn records the bytes read from a connection until they contain a
+// complete ClientHello, which is then parsed.
+type helloConn struct {
+	net.Conn
+
+	mu    sync.Mutex
+	buf   []byte
+	done  bool
+	hello *clientHello
+}
+
+func (c *helloConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.record(b[:n])
+	}
+	return n, err
+}
+
+func (c *helloConn) record(b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done {
+		return
+	}
+	c.buf = append(c.buf, b...)
+	msg, err := handshakeMessage(c.buf)
+	if err == nil && msg == nil && len(c.buf) <= maxClientHelloSize {
+		// Wait for more records
+		return
+	}
+	if msg != nil {
+		c.hello, _ = parseClientHello(msg)
+	}
+	c.done = true
+	c.buf = nil
+}
+
+func (c *helloConn) clientHello() *clientHello {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hello
+}
+
+// handshakeMessage returns the first handshake message in the TLS records in b.
+// It returns a nil message if b does not contain all of it yet.
+func handshakeMessage(b []byte) ([]byte, error) {
+	var msg []byte
+	for len(b) >= 5 {
+		if b[0] != 22 { // Handshake record
+			return nil, errInvalidClientHello
+		}
+		size := 5 + int(binary.BigEndian.Uint16(b[3:5]))
+		if len(b) < size {
+			break
+		}
+		msg = append(msg, b[5:size]...)
+		b = b[size:]
+		if len(msg) >= 4 {
+			msgSize := 4 + (int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3]))
+			if len(msg) >= msgSize {
+				return msg[:msgSize], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func readUint16s(s *cryptobyte.String, list *[]uint16) bool {
+	var v cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&v) {
+		return false
+	}
+	for !v.Empty() {
+		var n uint16
+		if !v.ReadUint16(&n) {
+			return false
+		}
+		*list = append(*list, n)
+	}
+	return true
+}
+
+// parseClientHello parses the ClientHello handshake message in b.
+func parseClientHello(b []byte) (*clientHello, error) {
+	s := cryptobyte.String(b)
+	var typ uint8
+	var body cryptobyte.String
+	if !s.ReadUint8(&typ) || typ != 1 || !s.ReadUint24LengthPrefixed(&body) {
+		return nil, errInvalidClientHello
+	}
+	hello := &clientHello{}
+	var sessionID, compression cryptobyte.String
+	if !body.ReadUint16(&hello.version) || !body.Skip(32) ||
+		!body.ReadUint8LengthPrefixed(&sessionID) ||
+		!readUint16s(&body, &hello.cipherSuites) ||
+		!body.ReadUint8LengthPrefixed(&compression) {
+		return nil, errInvalidClientHello
+	}
+	if body.Empty() {
+		return hello, nil
+	}
+	var extensions cryptobyte.String
+	if !body.ReadUint16LengthPrefixed(&extensions) || !body.Empty() {
+		return nil, errInvalidClientHello
+	}
+	for !extensions.Empty() {
+		var ext uint16
+		var data cryptobyte.String
+		if !extensions.ReadUint16(&ext) || !extensions.ReadUint16LengthPrefixed(&data) {
+			return nil, errInvalidClientHello
+		}
+		hello.extensions = append(hello.extensions, ext)
+		ok := true
+		switch ext {
+		case extServerName:
+			hello.serverName = true
+		case extSupportedGroups:
+			ok = readUint16s(&data, &hello.supportedGroups)
+		case extPointFormats:
+			var formats cryptobyte.String
+			if ok = data.ReadUint8LengthPrefixed(&formats); ok {
+				hello.pointFormats = append(hello.pointFormats, formats...)
+			}
+		case extSignatureAlgorithms:
+			ok = readUint16s(&data, &hello.signatureAlgorithms)
+		case extALPN:
+			var protos cryptobyte.String
+			ok = data.ReadUint16LengthPrefixed(&protos)
+			for ok && !protos.Empty() {
+				var proto cryptobyte.String
+				if ok = protos.ReadUint8LengthPrefixed(&proto); ok {
+					hello.alpn = append(hello.alpn, string(proto))
+				}
+			}
+		case extSupportedVersions:
+			var versions cryptobyte.String
+			ok = data.ReadUint8LengthPrefixed(&versions)
+			for ok && !versions.Empty() {
+				var v uint16
+				if ok = versions.ReadUint16(&v); ok {
+					hello.supportedVersions = append(hello.supportedVersions, v)
+				}
+			}
+		}
+		if !ok {
+			return nil, errInvalidClientHello
+		}
+	}
+	return hello, nil
+}
+
+// isGREASE returns true if v is a GREASE value, as defined by RFC 8701.
+func isGREASE(v uint16) bool { return v&0x0f0f == 0x0a0a && v>>8 == v&0xff }
+
+func withoutGREASE(values []uint16) []uint16 {
+	return slices.DeleteFunc(slices.Clone(values), isGREASE)
+}
+
+func joinInts[T uint8 | uint16](values []T, sep string) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(s, sep)
+}
+
+func joinHex(values []uint16) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(s, ",")
+}
+
+// ja3 returns the JA3 string of the ClientHello.
+func (h *clientHello) ja3() string {
+	return fmt.Sprintf("%d,%s,%s,%s,%s", h.version,
+		joinInts(withoutGREASE(h.cipherSuites), "-"),
+		joinInts(withoutGREASE(h.extensions), "-"),
+		joinInts(withoutGREASE(h.supportedGroups), "-"),
+		joinInts(h.pointFormats, "-"))
+}
+
+// ja4Version returns the version of v as used in JA4.
+func ja4Version(v uint16) string {
+	switch v {
+	case tls.VersionTLS13:
+		return "13"
+	case tls.VersionTLS12:
+		return "12"
+	case tls.VersionTLS11:
+		return "11"
+	case tls.VersionTLS10:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	case 0xfeff:
+		return "d1"
+	case 0xfefd:
+		return "d2"
+	case 0xfefc:
+		return "d3"
+	}
+	return "00"
+}
+
+func isAlphanumeric(c byte) bool {
+	return '0' <= c && c <= '9' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z'
+}
+
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:6])
+}
+
+// ja4 returns the JA4 fingerprint of the ClientHello, received over TCP.
+func (h *clientHello) ja4() string {
+	version := h.version
+	if versions := withoutGREASE(h.supportedVersions); len(versions) > 0 {
+		version = slices.Max(versions)
+	}
+	sni := "i"
+	if h.serverName {
+		sni = "d"
+	}
+	alpn := "00"
+	if len(h.alpn) > 0 && h.alpn[0] != "" {
+		first, last := h.alpn[0][0], h.alpn[0][len(h.alpn[0])-1]
+		if isAlphanumeric(first) && isAlphanumeric(last) {
+			alpn = string([]byte{first, last})
+		} else {
+			encoded := hex.EncodeToString([]byte(h.alpn[0]))
+			alpn = encoded[:1] + encoded[len(encoded)-1:]
+		}
+	}
+	ciphers := withoutGREASE(h.cipherSuites)
+	extensions := withoutGREASE(h.extensions)
+	a := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(version), sni, min(len(ciphers), 99), min(len(extensions), 99), alpn)
+
+	slices.Sort(ciphers)
+	// SNI and ALPN are already part of the first section
+	extensions = slices.DeleteFunc(extensions, func(ext uint16) bool { return ext == extServerName || ext == extALPN })
+	slices.Sort(extensions)
+	c := ""
+	if len(extensions) > 0 {
+		c = joinHex(extensions)
+		if len(h.signatureAlgorithms) > 0 {
+			c += "_" + joinHex(withoutGREASE(h.signatureAlgorithms))
+		}
+	}
+	return a + "_" + ja4Hash(joinHex(ciphers)) + "_" + ja4Hash(c)
+}
+
+func curveName(v uint16) string {
+	if name := tls.CurveID(v).String(); !strings.HasPrefix(name, "CurveID(") {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", v)
+}
+
+func (h *clientHello) fingerprint() *TLSFingerprint {
+	ja3 := h.ja3()
+	sum := md5.Sum([]byte(ja3))
+	fp := &TLSFingerprint{
+		JA3:        ja3,
+		JA3Hash:    hex.EncodeToString(sum[:]),
+		JA4:        h.ja4(),
+		Extensions: h.extensions,
+		ALPN:       h.alpn,
+	}
+	for _, v := range h.cipherSuites {
+		fp.CipherSuites = append(fp.CipherSuites, tls.CipherSuiteName(v))
+	}
+	for _, v := range h.supportedGroups {
+		fp.SupportedGroups = append(fp.SupportedGroups, curveName(v))
+	}
+	return fp
+}
+
+// fingerprintFromRequest returns the fingerprint of the ClientHello the
+// connection of r was opened with, if it was received by a native TLS listener.
+func fingerprintFromRequest(r *http.Request) *TLSFingerprint {
+	c, ok := r.Context().Value(clientHelloKey{}).(*helloConn)
+	if !ok {
+		return nil
+	}
+	hello := c.clientHello()
+	if hello == nil {
+		return nil
+	}
+	return hello.fingerprint()
+}
+
+// TLSHandler writes the fingerprint of the ClientHello of the connection as
+// JSON.
+func (s *Server) TLSHandler(w http.ResponseWriter, r *http.Request) *appError {
+	fp := fingerprintFromRequest(r)
+	if fp == nil {
+		err := errors.New("no TLS ClientHello available for this connection")
+		return notFound(err).WithMessage(err.Error()).AsJSON()
+	}
+	b, err := json.MarshalIndent(fp, "", "  ")
+	if err != nil {
+		return internalServerError(err).AsJSON()
+	}
+	w.Header().Set("Content-Type", jsonMediaType)
+	w.Write(b)
+	return nil
+}
@@ -0,0 +1,192 @@
+package http
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// clientHelloRecords returns a ClientHello split into two handshake records.
+func clientHelloRecords(t *testing.T) []byte {
+	var b cryptobyte.Builder
+	b.AddUint8(1) // ClientHello
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(tls.VersionTLS12)
+		b.AddBytes(make([]byte, 32)) // Random
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16(0x0a0a)
+			b.AddUint16(tls.TLS_AES_128_GCM_SHA256)
+			b.AddUint16(tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)
+		})
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddUint8(0) })
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			extension := func(typ uint16, f cryptobyte.BuilderContinuation) {
+				b.AddUint16(typ)
+				b.AddUint16LengthPrefixed(f)
+			}
+			extension(0x1a1a, func(b *cryptobyte.Builder) {})
+			extension(extServerName, func(b *cryptobyte.Builder) {
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint8(0)
+					b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte("example.com")) })
+				})
+			})
+			extension(extSupportedGroups, func(b *cryptobyte.Builder) {
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint16(0x2a2a)
+					b.AddUint16(uint16(tls.X25519))
+					b.AddUint16(uint16(tls.CurveP256))
+				})
+			})
+			extension(extPointFormats, func(b *cryptobyte.Builder) {
+				b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddUint8(0) })
+			})
+			extension(extSignatureAlgorithms, func(b *cryptobyte.Builder) {
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint16(uint16(tls.ECDSAWithP256AndSHA256))
+					b.AddUint16(uint16(tls.PSSWithSHA256))
+				})
+			})
+			extension(extALPN, func(b *cryptobyte.Builder) {
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					for _, proto := range []string{"h2", "http/1.1"} {
+						b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte(proto)) })
+					}
+				})
+			})
+			extension(extSupportedVersions, func(b *cryptobyte.Builder) {
+				b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint16(0x3a3a)
+					b.AddUint16(tls.VersionTLS13)
+					b.AddUint16(tls.VersionTLS12)
+				})
+			})
+		})
+	})
+	msg, err := b.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []byte
+	for _, fragment := range [][]byte{msg[:40], msg[40:]} {
+		records = append(records, 22, 3, 1, byte(len(fragment)>>8), byte(len(fragment)))
+		records = append(records, fragment...)
+	}
+	return records
+}
+
+func TestClientHelloFingerprint(t *testing.T) {
+	records := clientHelloRecords(t)
+	if msg, err := handshakeMessage(records[:len(records)-1]); err != nil || msg != nil {
+		t.Errorf("want incomplete message, got (%v, %v)", msg, err)
+	}
+	if _, err := handshakeMessage([]byte("GET / HTTP/1.1\r\n")); err == nil {
+		t.Error("expected error for non-handshake record")
+	}
+
+	// Records are reassembled regardless of how reads are split
+	c := &helloConn{}
+	for i := 0; i < len(records); i += 7 {
+		c.record(records[i:min(i+7, len(records))])
+	}
+	hello := c.clientHello()
+	if hello == nil {
+		t.Fatal("ClientHello not parsed")
+	}
+	want := &TLSFingerprint{
+		JA3:             "771,4865-49195,0-10-11-13-16-43,29-23,0",
+		JA3Hash:         "87991a9b84cb5b4bc5f84c5ecad46032",
+		JA4:             "t13d0206h2_777cda164f4b_fb71836bce29",
+		CipherSuites:    []string{"0x0A0A", "TLS_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		Extensions:      []uint16{0x1a1a, 0, 10, 11, 13, 16, 43},
+		SupportedGroups: []string{"0x2A2A", "X25519", "CurveP256"},
+		ALPN:            []string{"h2", "http/1.1"},
+	}
+	if got := hello.fingerprint(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	if _, err := parseClientHello([]byte{1, 0, 0, 2, 3, 3}); err == nil {
+		t.Error("expected error for truncated ClientHello")
+	}
+}
+
+func TestJA4(t *testing.T) {
+	var tests = []struct {
+		hello clientHello
+		out   string
+	}{
+		{clientHello{version: tls.VersionTLS12}, "t12i000000_000000000000_000000000000"},
+		{clientHello{version: tls.VersionTLS10, cipherSuites: []uint16{0x002f}, alpn: []string{"http/1.1"}}, "t10i0100h1_ba72b8082249_000000000000"},
+		{clientHello{version: tls.VersionTLS12, alpn: []string{"\xab"}}, "t12i0000ab_000000000000_000000000000"},
+		{clientHello{version: 0x1234, extensions: []uint16{0x0017}}, "t00i000100_000000000000_" + ja4Hash("0017")},
+	}
+	for _, tt := range tests {
+		if got := tt.hello.ja4(); got != tt.out {
+			t.Errorf("ja4(%+v) = %q, want %q", tt.hello, got, tt.out)
+		}
+	}
+}
+
+func TestTLSHandler(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "localhost")
+	certs, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testServer()
+	addr := freeAddr(t)
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.ListenAndServeTLS("tcp", addr, &tls.Config{GetCertificate: certs.GetCertificate}) }()
+	defer s.Shutdown(t.Context())
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	var res *http.Response
+	for i := 0; ; i++ {
+		res, err = client.Get("https://" + addr + "/tls")
+		if err == nil {
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-errCh:
+			t.Fatal(err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("want status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	var fp TLSFingerprint
+	if err := json.NewDecoder(res.Body).Decode(&fp); err != nil {
+		t.Fatal(err)
+	}
+	// No SNI is sent when connecting to an IP address
+	if !strings.HasPrefix(fp.JA4, "t13i") {
+		t.Errorf("want JA4 of TLS 1.3 without SNI, got %q", fp.JA4)
+	}
+	if len(fp.JA3Hash) != 32 || len(fp.CipherSuites) == 0 || len(fp.Extensions) == 0 {
+		t.Errorf("incomplete fingerprint: %+v", fp)
+	}
+
+	// Plain HTTP has no fingerprint
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tls", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("want status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -1,15 +1,21 @@
 package http
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
-	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"net/http/pprof"
+	"os"
 
 	"github.com/mpolden/echoip/iputil"
 	"github.com/mpolden/echoip/iputil/geo"
@@ -18,49 +24,155 @@ import (
 	"math/big"
 	"net"
 	"net/http"
+	"slices"
 	"strconv"
+	"time"
 )
 
 const (
 	jsonMediaType = "application/json"
 	textMediaType = "text/plain"
+
+	// Maximum number of bytes read by a banner probe
+	bannerLimit = 256
+
+	// Maximum number of reverse names that are forward-confirmed
+	maxVerifiedNames = 4
 )
 
 type Server struct {
-	Template   string
-	IPHeaders  []string
-	LookupAddr func(net.IP) (string, error)
-	LookupPort func(net.IP, uint64) error
-	cache      *Cache
-	gr         geo.Reader
-	profile    bool
-	Sponsor    bool
+	Template          string
+	IPHeaders         []string
+	RedactHeaders     []string
+	UserAgentParser   *useragent.Parser
+	LookupAddr        func(context.Context, net.IP) ([]string, error)
+	LookupIP          func(context.Context, string) ([]net.IP, error)
+	LookupPort        func(context.Context, net.IP, uint64) error
+	LookupTimeout     time.Duration
+	ProbeTLS          func(context.Context, net.IP, uint64) (tls.ConnectionState, error)
+	ProbeBanner       func(context.Context, net.IP, uint64, int) ([]byte, error)
+	LookupUDPPort     func(context.Context, net.IP, uint64, []byte) (string, error)
+	UDPPayload        string
+	LookupHTTP        func(context.Context, net.IP, uint64) (iputil.HTTPResult, error)
+	PortLimit         int
+	PortConcurrency   int
+	PortTimeout       time.Duration
+	PortMaxDials      int
+	PortRateLimit     int
+	PortAllow         []uint64
+	PortDeny          []uint64
+	PortAllowPrivate  bool
+	dials             dialLimiter
+	portRate          rateLimiter
+	RateLimits        map[string]RateLimit
+	RateLimitExempt   []*net.IPNet
+	Timeouts          Timeouts
+	MaxHeaderBytes    int
+	DrainDelay        time.Duration
+	TLSRedirectPort   string
+	ACMEChallenge     func(http.Handler) http.Handler
+	UnixSocketMode    os.FileMode
+	UnixSocketGroup   string
+	DualStackIPv4Host string
+	DualStackIPv6Host string
+	DualStackKey      []byte
+	dualStack         dualStack
+	mu                sync.Mutex
+	servers           []shutdowner
+	limiters          map[string]*rateLimiter
+	altSvc            string
+	draining          atomic.Bool
+	cache             *Cache
+	lookups           lookupGroup
+	gr                geo.Reader
+	profile           bool
+	Sponsor           bool
 }
 
 type Response struct {
-	IP         net.IP               `json:"ip"`
-	IPDecimal  *big.Int             `json:"ip_decimal"`
-	Country    string               `json:"country,omitempty"`
-	CountryISO string               `json:"country_iso,omitempty"`
-	CountryEU  bool                 `json:"country_eu"`
-	RegionName string               `json:"region_name,omitempty"`
-	RegionCode string               `json:"region_code,omitempty"`
-	MetroCode  uint                 `json:"metro_code,omitempty"`
-	PostalCode string               `json:"zip_code,omitempty"`
-	City       string               `json:"city,omitempty"`
-	Latitude   float64              `json:"latitude,omitempty"`
-	Longitude  float64              `json:"longitude,omitempty"`
-	Timezone   string               `json:"time_zone,omitempty"`
-	ASN        string               `json:"asn,omitempty"`
-	ASNOrg     string               `json:"asn_org,omitempty"`
-	Hostname   string               `json:"hostname,omitempty"`
-	UserAgent  *useragent.UserAgent `json:"user_agent,omitempty"`
+	IP               net.IP               `json:"ip"`
+	IPDecimal        *big.Int             `json:"ip_decimal"`
+	Country          string               `json:"country,omitempty"`
+	CountryISO       string               `json:"country_iso,omitempty"`
+	CountryEU        bool                 `json:"country_eu"`
+	RegionName       string               `json:"region_name,omitempty"`
+	RegionCode       string               `json:"region_code,omitempty"`
+	MetroCode        uint                 `json:"metro_code,omitempty"`
+	PostalCode       string               `json:"zip_code,omitempty"`
+	City             string               `json:"city,omitempty"`
+	Latitude         float64              `json:"latitude,omitempty"`
+	Longitude        float64              `json:"longitude,omitempty"`
+	Timezone         string               `json:"time_zone,omitempty"`
+	ASN              string               `json:"asn,omitempty"`
+	ASNOrg           string               `json:"asn_org,omitempty"`
+	Network          string               `json:"network,omitempty"`
+	Hostname         string               `json:"hostname,omitempty"`
+	Hostnames        []string             `json:"hostnames,omitempty"`
+	HostnameVerified *bool                `json:"hostname_verified,omitempty"`
+	UserAgent        *useragent.UserAgent `json:"user_agent,omitempty"`
+	Listener         *Listener            `json:"listener,omitempty"`
+	Protocol         string               `json:"protocol,omitempty"`
+	TLS              *TLSFingerprint      `json:"tls,omitempty"`
 }
 
 type PortResponse struct {
-	IP        net.IP `json:"ip"`
-	Port      uint64 `json:"port"`
-	Reachable bool   `json:"reachable"`
+	IP         net.IP   `json:"ip"`
+	Port       uint64   `json:"port"`
+	Protocol   string   `json:"protocol,omitempty"`
+	Reachable  bool     `json:"reachable"`
+	State      string   `json:"state,omitempty"`
+	TLS        *PortTLS `json:"tls,omitempty"`
+	Banner     string   `json:"banner,omitempty"`
+	ProbeError string   `json:"probe_error,omitempty"`
+}
+
+// text returns a single line describing the state of the port and the outcome
+// of any probe.
+func (r PortResponse) text() string {
+	state := r.State
+	if state == "" {
+		state = "unreachable"
+		if r.Reachable {
+			state = "reachable"
+		}
+	}
+	line := fmt.Sprintf("%d %s", r.Port, state)
+	if r.TLS != nil {
+		line += fmt.Sprintf(" tls=%q", r.TLS.Version)
+		if r.TLS.ALPN != "" {
+			line += fmt.Sprintf(" alpn=%q", r.TLS.ALPN)
+		}
+		if r.TLS.Subject != "" {
+			line += fmt.Sprintf(" subject=%q", r.TLS.Subject)
+		}
+	}
+	if r.Banner != "" {
+		line += fmt.Sprintf(" banner=%q", r.Banner)
+	}
+	if r.ProbeError != "" {
+		line += fmt.Sprintf(" probe_error=%q", r.ProbeError)
+	}
+	return line
+}
+
+type HTTPResponse struct {
+	IP           net.IP `json:"ip"`
+	Port         uint64 `json:"port"`
+	Reachable    bool   `json:"reachable"`
+	StatusCode   int    `json:"status_code,omitempty"`
+	ResponseTime int64  `json:"response_time_ms,omitempty"`
+	Server       string `json:"server,omitempty"`
+	Location     string `json:"location,omitempty"`
+	TLSRequired  bool   `json:"tls_required"`
+	Error        string `json:"error,omitempty"`
+}
+
+type PortTLS struct {
+	Version  string    `json:"version"`
+	ALPN     string    `json:"alpn,omitempty"`
+	Subject  string    `json:"subject,omitempty"`
+	SANs     []string  `json:"sans,omitempty"`
+	NotAfter time.Time `json:"not_after"`
 }
 
 func New(db geo.Reader, cache *Cache, profile bool) *Server {
@@ -75,6 +187,21 @@ func ipFromForwardedForHeader(v string) string {
 	return before
 }
 
+// ipFromHeaders returns the IP given by the first of headers that is set in r,
+// along with the name of that header.
+func ipFromHeaders(headers []string, r *http.Request) (string, string) {
+	for _, header := range headers {
+		remoteIP := r.Header.Get(header)
+		if http.CanonicalHeaderKey(header) == "X-Forwarded-For" {
+			remoteIP = ipFromForwardedForHeader(remoteIP)
+		}
+		if remoteIP != "" {
+			return remoteIP, header
+		}
+	}
+	return "", ""
+}
+
 // ipFromRequest detects the IP address for this transaction.
 //
 // * `headers` - the specific HTTP headers to trust
@@ -88,18 +215,12 @@ func ipFromRequest(headers []string, r *http.Request, customIP bool) (net.IP, er
 		}
 	}
 	if remoteIP == "" {
-		for _, header := range headers {
-			remoteIP = r.Header.Get(header)
-			if http.CanonicalHeaderKey(header) == "X-Forwarded-For" {
-				remoteIP = ipFromForwardedForHeader(remoteIP)
-			}
-			if remoteIP != "" {
-				break
-			}
-		}
+		remoteIP, _ = ipFromHeaders(headers, r)
 	}
+	fromRemoteAddr := false
 	if remoteIP == "" {
 		remoteIP = r.RemoteAddr
+		fromRemoteAddr = true
 	}
 	ip := net.ParseIP(remoteIP)
 	if ip == nil {
@@ -111,6 +232,11 @@ func ipFromRequest(headers []string, r *http.Request, customIP bool) (net.IP, er
 			remoteIP = host
 			ip = net.ParseIP(remoteIP)
 		}
+		if ip == nil && fromRemoteAddr {
+			// E.g. a connection on a Unix socket, which only has a trusted
+			// header to go by
+			return nil, fmt.Errorf("could not determine IP: remote address %q is not an IP address and no trusted header is set", r.RemoteAddr)
+		}
 		if ip == nil {
 			return nil, fmt.Errorf("could not parse IP: %s", remoteIP)
 		}
@@ -118,11 +244,16 @@ func ipFromRequest(headers []string, r *http.Request, customIP bool) (net.IP, er
 	return ip, nil
 }
 
-func userAgentFromRequest(r *http.Request) *useragent.UserAgent {
+func (s *Server) userAgentFromRequest(r *http.Request) *useragent.UserAgent {
 	var userAgent *useragent.UserAgent
 	userAgentRaw := r.UserAgent()
 	if userAgentRaw != "" {
-		parsed := useragent.Parse(userAgentRaw)
+		var parsed useragent.UserAgent
+		if s.UserAgentParser != nil {
+			parsed = s.UserAgentParser.Parse(userAgentRaw)
+		} else {
+			parsed = useragent.Parse(userAgentRaw)
+		}
 		userAgent = &parsed
 	}
 	return userAgent
@@ -133,63 +264,377 @@ func (s *Server) newResponse(r *http.Request) (Response, error) {
 	if err != nil {
 		return Response{}, err
 	}
-	response, ok := s.cache.Get(ip)
-	if ok {
-		// Do not cache user agent
-		response.UserAgent = userAgentFromRequest(r)
+	response, err := s.Lookup(r.Context(), ip)
+	if err != nil {
+		return Response{}, err
+	}
+	// Do not cache user agent, listener, protocol or TLS fingerprint
+	response.UserAgent = s.userAgentFromRequest(r)
+	response.Listener = listenerFromRequest(r)
+	response.Protocol = protocolFromRequest(r)
+	response.TLS = fingerprintFromRequest(r)
+	return response, nil
+}
+
+// Lookup returns the geo and hostname information of ip, from the cache if
+// possible. Concurrent lookups of the same IP are coalesced.
+func (s *Server) Lookup(ctx context.Context, ip net.IP) (Response, error) {
+	if response, ok := s.cache.Get(ip); ok {
 		return response, nil
 	}
-	ipDecimal := iputil.ToDecimal(ip)
-	country, _ := s.gr.Country(ip)
-	city, _ := s.gr.City(ip)
-	asn, _ := s.gr.ASN(ip)
-	var hostname string
+	// The lookup outlives the caller if it goes away, so that other callers
+	// waiting for the same IP still get a result
+	lookupCtx := context.WithoutCancel(ctx)
+	response, _, err := s.lookups.Do(ctx, ip, func() Response {
+		response, complete := s.lookup(lookupCtx, ip)
+		if complete {
+			s.cache.Set(ip, response)
+		}
+		return response
+	})
+	return response, err
+}
+
+// lookup performs geo and hostname lookups for ip in parallel, bounded by
+// LookupTimeout. Hostname is left empty if its lookup does not finish in time.
+// The returned bool is false if any lookup was cut short, in which case the
+// response should not be cached.
+func (s *Server) lookup(ctx context.Context, ip net.IP) (Response, bool) {
+	if s.LookupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.LookupTimeout)
+		defer cancel()
+	}
+	hostnameCh := make(chan hostname, 1)
 	if s.LookupAddr != nil {
-		hostname, _ = s.LookupAddr(ip)
+		go func() { hostnameCh <- s.lookupHostname(ctx, ip) }()
+	} else {
+		hostnameCh <- hostname{}
+	}
+	ipDecimal := iputil.ToDecimal(ip)
+	country, _ := s.gr.Country(ctx, ip)
+	city, _ := s.gr.City(ctx, ip)
+	asn, _ := s.gr.ASN(ctx, ip)
+	var host hostname
+	select {
+	case host = <-hostnameCh:
+	case <-ctx.Done():
 	}
 	var autonomousSystemNumber string
 	if asn.AutonomousSystemNumber > 0 {
 		autonomousSystemNumber = fmt.Sprintf("AS%d", asn.AutonomousSystemNumber)
 	}
-	response = Response{
-		IP:         ip,
-		IPDecimal:  ipDecimal,
-		Country:    country.Name,
-		CountryISO: country.ISO,
-		CountryEU:  country.IsEU,
-		RegionName: city.RegionName,
-		RegionCode: city.RegionCode,
-		MetroCode:  city.MetroCode,
-		PostalCode: city.PostalCode,
-		City:       city.Name,
-		Latitude:   city.Latitude,
-		Longitude:  city.Longitude,
-		Timezone:   city.Timezone,
-		ASN:        autonomousSystemNumber,
-		ASNOrg:     asn.AutonomousSystemOrganization,
-		Hostname:   hostname,
-	}
-	s.cache.Set(ip, response)
-	response.UserAgent = userAgentFromRequest(r)
-	return response, nil
+	var network string
+	if asn.Network != nil {
+		network = asn.Network.String()
+	}
+	response := Response{
+		IP:               ip,
+		IPDecimal:        ipDecimal,
+		Country:          country.Name,
+		CountryISO:       country.ISO,
+		CountryEU:        country.IsEU,
+		RegionName:       city.RegionName,
+		RegionCode:       city.RegionCode,
+		MetroCode:        city.MetroCode,
+		PostalCode:       city.PostalCode,
+		City:             city.Name,
+		Latitude:         city.Latitude,
+		Longitude:        city.Longitude,
+		Timezone:         city.Timezone,
+		ASN:              autonomousSystemNumber,
+		ASNOrg:           asn.AutonomousSystemOrganization,
+		Network:          network,
+		Hostname:         host.name,
+		Hostnames:        host.names,
+		HostnameVerified: host.verified,
+	}
+	return response, ctx.Err() == nil
 }
 
-func (s *Server) newPortResponse(r *http.Request) (PortResponse, error) {
-	lastElement := filepath.Base(r.URL.Path)
-	port, err := strconv.ParseUint(lastElement, 10, 16)
-	if err != nil || port < 1 || port > 65535 {
-		return PortResponse{Port: port}, fmt.Errorf("invalid port: %s", lastElement)
+type hostname struct {
+	name     string
+	names    []string
+	verified *bool
+}
+
+// lookupHostname performs a reverse lookup of ip. If LookupIP is set, the first
+// maxVerifiedNames names are concurrently forward-confirmed by checking that they
+// resolve back to ip. The preferred name is the first confirmed one, if any.
+func (s *Server) lookupHostname(ctx context.Context, ip net.IP) hostname {
+	names, err := s.LookupAddr(ctx, ip)
+	if err != nil || len(names) == 0 {
+		return hostname{}
+	}
+	host := hostname{name: names[0], names: names}
+	if s.LookupIP == nil {
+		return host
+	}
+	confirmed := make([]bool, min(len(names), maxVerifiedNames))
+	var wg sync.WaitGroup
+	for i := range confirmed {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			confirmed[i] = iputil.Resolves(ctx, s.LookupIP, names[i], ip)
+		}()
+	}
+	wg.Wait()
+	i := slices.Index(confirmed, true)
+	if i >= 0 {
+		host.name = names[i]
+	}
+	verified := i >= 0
+	host.verified = &verified
+	return host
+}
+
+// newPortResponses checks the ports given in the request path. The returned
+// bool is true if the path contains a list or range of ports, in which case
+// the responses should be presented as a list.
+func (s *Server) newPortResponses(r *http.Request) ([]PortResponse, bool, error) {
+	protocol := "tcp"
+	lastElement := strings.TrimPrefix(r.URL.Path, "/port/")
+	if s.LookupUDPPort != nil && strings.HasPrefix(lastElement, "udp/") {
+		protocol = "udp"
+		lastElement = strings.TrimPrefix(lastElement, "udp/")
+	}
+	limit := s.PortLimit
+	if limit < 1 {
+		limit = defaultPortLimit
+	}
+	ports, err := parsePorts(lastElement, limit)
+	if err != nil {
+		return nil, false, err
+	}
+	multi := strings.ContainsAny(lastElement, ",-")
+	probe := r.URL.Query().Get("probe")
+	if err := s.checkProbe(protocol, probe); err != nil {
+		return nil, false, err
 	}
 	ip, err := ipFromRequest(s.IPHeaders, r, false)
 	if err != nil {
-		return PortResponse{Port: port}, err
+		return nil, false, err
+	}
+	if err := s.checkPortAccess(ip, ports); err != nil {
+		return nil, false, err
+	}
+	responses := make([]PortResponse, len(ports))
+	errs := make([]error, len(ports))
+	var wg sync.WaitGroup
+	for i, port := range ports {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], errs[i] = s.newPortResponse(r.Context(), ip, port, protocol, probe)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return responses, multi, nil
+}
+
+// checkProbe returns an error if probe is not supported for protocol.
+func (s *Server) checkProbe(protocol, probe string) error {
+	switch {
+	case probe == "":
+	case protocol == "tcp" && probe == "tls" && s.ProbeTLS != nil:
+	case protocol == "tcp" && probe == "banner" && s.ProbeBanner != nil:
+	case protocol == "udp" && (probe == "dns" || probe == "wireguard"):
+	default:
+		return fmt.Errorf("invalid probe: %s", probe)
+	}
+	return nil
+}
+
+// CheckPort checks whether port is reachable on ip, subject to the same
+// restrictions as the /port endpoint. Protocol is either "tcp" or "udp", and
+// defaults to "tcp".
+func (s *Server) CheckPort(ctx context.Context, ip net.IP, port uint64, protocol, probe string) (PortResponse, error) {
+	if s.LookupPort == nil {
+		return PortResponse{}, errors.New("port checks are disabled")
+	}
+	switch protocol {
+	case "":
+		protocol = "tcp"
+	case "tcp":
+	case "udp":
+		if s.LookupUDPPort == nil {
+			return PortResponse{}, errors.New("UDP port checks are disabled")
+		}
+	default:
+		return PortResponse{}, fmt.Errorf("invalid protocol: %s", protocol)
+	}
+	if port < 1 || port > 65535 {
+		return PortResponse{}, fmt.Errorf("invalid port: %d", port)
+	}
+	if err := s.checkProbe(protocol, probe); err != nil {
+		return PortResponse{}, err
+	}
+	if err := s.checkPortAccess(ip, []uint64{port}); err != nil {
+		return PortResponse{}, err
+	}
+	return s.newPortResponse(ctx, ip, port, protocol, probe)
+}
+
+func (s *Server) newHTTPResponse(r *http.Request) (HTTPResponse, error) {
+	lastElement := strings.TrimPrefix(r.URL.Path, "/http/")
+	port, err := parsePort(lastElement)
+	if err != nil {
+		return HTTPResponse{}, err
+	}
+	ip, err := ipFromRequest(s.IPHeaders, r, false)
+	if err != nil {
+		return HTTPResponse{}, err
+	}
+	if err := s.checkPortAccess(ip, []uint64{port}); err != nil {
+		return HTTPResponse{}, err
+	}
+	ctx, release, err := s.acquirePort(r.Context(), ip)
+	if err != nil {
+		return HTTPResponse{}, err
+	}
+	defer release()
+	response := HTTPResponse{IP: ip, Port: port}
+	result, err := s.LookupHTTP(ctx, ip, port)
+	if err != nil {
+		response.Error = err.Error()
+		return response, nil
+	}
+	response.Reachable = true
+	response.StatusCode = result.StatusCode
+	response.ResponseTime = result.ResponseTime.Milliseconds()
+	response.Server = result.Server
+	response.Location = result.Location
+	response.TLSRequired = result.TLSRequired
+	return response, nil
+}
+
+// checkPortAccess returns an error if ip is not allowed to check ports.
+func (s *Server) checkPortAccess(ip net.IP, ports []uint64) error {
+	if !s.PortAllowPrivate && !isPublic(ip) {
+		return &refusedError{message: fmt.Sprintf("refusing to check ports of non-public address: %s", ip), code: http.StatusForbidden}
+	}
+	for _, port := range ports {
+		if (len(s.PortAllow) > 0 && !slices.Contains(s.PortAllow, port)) || slices.Contains(s.PortDeny, port) {
+			return &refusedError{message: fmt.Sprintf("port not allowed: %d", port), code: http.StatusForbidden}
+		}
+	}
+	if s.PortRateLimit > 0 {
+		if len(ports) > s.PortRateLimit {
+			return &refusedError{message: fmt.Sprintf("too many ports: at most %d can be checked per minute", s.PortRateLimit), code: http.StatusTooManyRequests}
+		}
+		rate := float64(s.PortRateLimit)
+		if ok, wait := s.portRate.allow(rateLimitKey(ip), len(ports), rate/60, rate); !ok {
+			return &refusedError{message: "too many port checks, please try again later", code: http.StatusTooManyRequests, retryAfter: wait}
+		}
+	}
+	return nil
+}
+
+// acquirePort waits for a dial slot for ip and returns a context bounded by
+// PortTimeout. The returned function must be called when the port check is
+// done.
+func (s *Server) acquirePort(ctx context.Context, ip net.IP) (context.Context, func(), error) {
+	concurrency := s.PortConcurrency
+	if concurrency < 1 {
+		concurrency = defaultPortConcurrency
+	}
+	release, err := s.dials.acquire(ctx, rateLimitKey(ip), concurrency, s.PortMaxDials)
+	if err != nil {
+		return nil, nil, err
+	}
+	timeout := s.PortTimeout
+	if timeout <= 0 {
+		timeout = iputil.DefaultPortTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		release()
+	}, nil
+}
+
+func (s *Server) newPortResponse(ctx context.Context, ip net.IP, port uint64, protocol, probe string) (PortResponse, error) {
+	ctx, release, err := s.acquirePort(ctx, ip)
+	if err != nil {
+		return PortResponse{}, err
 	}
-	err = s.LookupPort(ip, port)
-	return PortResponse{
+	defer release()
+	if protocol == "udp" {
+		return s.newUDPPortResponse(ctx, ip, port, probe), nil
+	}
+	err = s.LookupPort(ctx, ip, port)
+	response := PortResponse{
 		IP:        ip,
 		Port:      port,
 		Reachable: err == nil,
-	}, nil
+	}
+	if response.Reachable && probe != "" {
+		err = s.probePort(ctx, probe, &response)
+		if err != nil {
+			response.ProbeError = err.Error()
+		}
+	}
+	return response, nil
+}
+
+func (s *Server) newUDPPortResponse(ctx context.Context, ip net.IP, port uint64, probe string) PortResponse {
+	var payload []byte
+	switch probe {
+	case "dns":
+		payload = iputil.DNSProbe()
+	case "wireguard":
+		payload = iputil.WireGuardProbe()
+	default:
+		payload = []byte(s.UDPPayload)
+	}
+	response := PortResponse{
+		IP:       ip,
+		Port:     port,
+		Protocol: "udp",
+	}
+	state, err := s.LookupUDPPort(ctx, ip, port, payload)
+	if err != nil {
+		response.ProbeError = err.Error()
+	}
+	response.State = state
+	response.Reachable = state == iputil.UDPOpen
+	return response
+}
+
+func (s *Server) probePort(ctx context.Context, probe string, response *PortResponse) error {
+	switch probe {
+	case "tls":
+		state, err := s.ProbeTLS(ctx, response.IP, response.Port)
+		if err != nil {
+			return err
+		}
+		response.TLS = &PortTLS{
+			Version: tls.VersionName(state.Version),
+			ALPN:    state.NegotiatedProtocol,
+		}
+		if len(state.PeerCertificates) > 0 {
+			cert := state.PeerCertificates[0]
+			response.TLS.Subject = cert.Subject.String()
+			response.TLS.SANs = append(response.TLS.SANs, cert.DNSNames...)
+			for _, ip := range cert.IPAddresses {
+				response.TLS.SANs = append(response.TLS.SANs, ip.String())
+			}
+			response.TLS.NotAfter = cert.NotAfter.UTC()
+		}
+	case "banner":
+		banner, err := s.ProbeBanner(ctx, response.IP, response.Port, bannerLimit)
+		if err != nil {
+			return err
+		}
+		response.Banner = strings.TrimRight(strings.ToValidUTF8(string(banner), ""), "\r\n\x00 ")
+	}
+	return nil
 }
 
 func (s *Server) CLIHandler(w http.ResponseWriter, r *http.Request) *appError {
@@ -201,6 +646,20 @@ func (s *Server) CLIHandler(w http.ResponseWriter, r *http.Request) *appError {
 	return nil
 }
 
+func (s *Server) CLIProtocolHandler(w http.ResponseWriter, r *http.Request) *appError {
+	fmt.Fprintln(w, protocolFromRequest(r))
+	return nil
+}
+
+func (s *Server) CLIHostnameHandler(w http.ResponseWriter, r *http.Request) *appError {
+	response, err := s.newResponse(r)
+	if err != nil {
+		return badRequest(err).WithMessage(err.Error()).AsJSON()
+	}
+	fmt.Fprintln(w, response.Hostname)
+	return nil
+}
+
 func (s *Server) CLICountryHandler(w http.ResponseWriter, r *http.Request) *appError {
 	response, err := s.newResponse(r)
 	if err != nil {
@@ -271,6 +730,13 @@ func (s *Server) JSONHandler(w http.ResponseWriter, r *http.Request) *appError {
 
 func (s *Server) HealthHandler(w http.ResponseWriter, r *http.Request) *appError {
 	w.Header().Set("Content-Type", jsonMediaType)
+	if s.draining.Load() {
+		// Signal load balancers to stop sending traffic while in-flight
+		// requests complete
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"draining"}`))
+		return nil
+	}
 	w.Write([]byte(`{"status":"OK"}`))
 	return nil
 }
@@ -282,9 +748,32 @@ func (s *Server) HeadHandler(w http.ResponseWriter, r *http.Request) *appError {
 }
 
 func (s *Server) PortHandler(w http.ResponseWriter, r *http.Request) *appError {
-	response, err := s.newPortResponse(r)
+	responses, multi, err := s.newPortResponses(r)
 	if err != nil {
-		return badRequest(err).WithMessage(err.Error()).AsJSON()
+		return portError(w, err)
+	}
+	var v any = responses
+	if !multi {
+		v = responses[0]
+	} else if r.Header.Get("Accept") != jsonMediaType && (cliMatcher(r) || r.Header.Get("Accept") == textMediaType) {
+		for _, response := range responses {
+			fmt.Fprintln(w, response.text())
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return internalServerError(err).AsJSON()
+	}
+	w.Header().Set("Content-Type", jsonMediaType)
+	w.Write(b)
+	return nil
+}
+
+func (s *Server) HTTPHandler(w http.ResponseWriter, r *http.Request) *appError {
+	response, err := s.newHTTPResponse(r)
+	if err != nil {
+		return portError(w, err)
 	}
 	b, err := json.MarshalIndent(response, "", "  ")
 	if err != nil {
@@ -295,6 +784,21 @@ func (s *Server) PortHandler(w http.ResponseWriter, r *http.Request) *appError {
 	return nil
 }
 
+// portError converts an error from a port check into an appError.
+func portError(w http.ResponseWriter, err error) *appError {
+	var refused *refusedError
+	if !errors.As(err, &refused) {
+		return badRequest(err).WithMessage(err.Error()).AsJSON()
+	}
+	if refused.retryAfter > 0 {
+		w.Header().Set("Retry-After", formatSeconds(refused.retryAfter))
+	}
+	if refused.code == http.StatusTooManyRequests {
+		return tooManyRequests(err).WithMessage(err.Error()).AsJSON()
+	}
+	return forbidden(err).WithMessage(err.Error()).AsJSON()
+}
+
 func (s *Server) cacheResizeHandler(w http.ResponseWriter, r *http.Request) *appError {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -339,6 +843,26 @@ func (s *Server) cacheHandler(w http.ResponseWriter, r *http.Request) *appError
 	return nil
 }
 
+func (s *Server) lookupHandler(w http.ResponseWriter, r *http.Request) *appError {
+	lookupStats := s.lookups.Stats()
+	var data = struct {
+		InFlight  int    `json:"in_flight"`
+		Lookups   uint64 `json:"lookups"`
+		Coalesced uint64 `json:"coalesced"`
+	}{
+		lookupStats.InFlight,
+		lookupStats.Lookups,
+		lookupStats.Coalesced,
+	}
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return internalServerError(err).AsJSON()
+	}
+	w.Header().Set("Content-Type", jsonMediaType)
+	w.Write(b)
+	return nil
+}
+
 func (s *Server) DefaultHandler(w http.ResponseWriter, r *http.Request) *appError {
 	response, err := s.newResponse(r)
 	if err != nil {
@@ -364,6 +888,7 @@ func (s *Server) DefaultHandler(w http.ResponseWriter, r *http.Request) *appErro
 		Port           bool
 		Sponsor        bool
 		ExplicitLookup bool
+		DualStack      bool
 	}{
 		response,
 		r.Host,
@@ -375,6 +900,7 @@ func (s *Server) DefaultHandler(w http.ResponseWriter, r *http.Request) *appErro
 		s.LookupPort != nil,
 		s.Sponsor,
 		r.URL.Query().Has("ip"),
+		s.dualStackEnabled(),
 	}
 	if err := t.Execute(w, &data); err != nil {
 		return internalServerError(err)
@@ -391,7 +917,7 @@ func NotFoundHandler(w http.ResponseWriter, r *http.Request) *appError {
 }
 
 func cliMatcher(r *http.Request) bool {
-	ua := useragent.Parse(r.UserAgent())
+	ua := useragent.ParseProduct(r.UserAgent())
 	switch ua.Product {
 	case "curl", "HTTPie", "httpie-go", "Wget", "fetch libfetch", "Go", "Go-http-client", "ddclient", "Mikrotik", "xh":
 		return true
@@ -443,21 +969,38 @@ func (s *Server) Handler() http.Handler {
 	// Health
 	r.Route("HEAD", "/", s.HeadHandler)
 
+	cliLimit := s.rateLimited("cli")
+	jsonLimit := s.rateLimited("json")
+	portLimit := s.rateLimited("port")
+
 	// JSON
-	r.Route("GET", "/", s.JSONHandler).Header("Accept", jsonMediaType)
-	r.Route("GET", "/json", s.JSONHandler)
+	r.Route("GET", "/", jsonLimit(s.JSONHandler)).Header("Accept", jsonMediaType)
+	r.Route("GET", "/json", jsonLimit(s.JSONHandler))
 
 	// CLI
-	r.Route("GET", "/", s.CLIHandler).MatcherFunc(cliMatcher)
-	r.Route("GET", "/", s.CLIHandler).Header("Accept", textMediaType)
-	r.Route("GET", "/ip", s.CLIHandler)
+	r.Route("GET", "/", cliLimit(s.CLIHandler)).MatcherFunc(cliMatcher)
+	r.Route("GET", "/", cliLimit(s.CLIHandler)).Header("Accept", textMediaType)
+	r.Route("GET", "/ip", cliLimit(s.CLIHandler))
+	r.Route("GET", "/protocol", cliLimit(s.CLIProtocolHandler))
+	r.Route("GET", "/headers", cliLimit(s.HeadersHandler))
+	r.Route("GET", "/request", jsonLimit(s.RequestHandler))
+	r.Route("GET", "/tls", jsonLimit(s.TLSHandler))
 	if !s.gr.IsEmpty() {
-		r.Route("GET", "/country", s.CLICountryHandler)
-		r.Route("GET", "/country-iso", s.CLICountryISOHandler)
-		r.Route("GET", "/city", s.CLICityHandler)
-		r.Route("GET", "/coordinates", s.CLICoordinatesHandler)
-		r.Route("GET", "/asn", s.CLIASNHandler)
-		r.Route("GET", "/asn-org", s.CLIASNOrgHandler)
+		r.Route("GET", "/country", cliLimit(s.CLICountryHandler))
+		r.Route("GET", "/country-iso", cliLimit(s.CLICountryISOHandler))
+		r.Route("GET", "/city", cliLimit(s.CLICityHandler))
+		r.Route("GET", "/coordinates", cliLimit(s.CLICoordinatesHandler))
+		r.Route("GET", "/asn", cliLimit(s.CLIASNHandler))
+		r.Route("GET", "/asn-org", cliLimit(s.CLIASNOrgHandler))
+	}
+
+	if s.LookupAddr != nil {
+		r.Route("GET", "/hostname", cliLimit(s.CLIHostnameHandler))
+	}
+
+	// Dual-stack
+	if s.dualStackEnabled() {
+		r.Route("GET", "/dualstack", jsonLimit(s.DualStackHandler))
 	}
 
 	// Browser
@@ -467,13 +1010,18 @@ func (s *Server) Handler() http.Handler {
 
 	// Port testing
 	if s.LookupPort != nil {
-		r.RoutePrefix("GET", "/port/", s.PortHandler)
+		r.RoutePrefix("GET", "/port/", portLimit(s.PortHandler))
+	}
+	if s.LookupHTTP != nil {
+		r.RoutePrefix("GET", "/http/", portLimit(s.HTTPHandler))
 	}
 
 	// Profiling
 	if s.profile {
 		r.Route("POST", "/debug/cache/resize", s.cacheResizeHandler)
 		r.Route("GET", "/debug/cache/", s.cacheHandler)
+		r.Route("GET", "/debug/lookup/", s.lookupHandler)
+		r.Route("GET", "/debug/vars", wrapHandlerFunc(expvar.Handler().ServeHTTP))
 		r.Route("GET", "/debug/pprof/cmdline", wrapHandlerFunc(pprof.Cmdline))
 		r.Route("GET", "/debug/pprof/profile", wrapHandlerFunc(pprof.Profile))
 		r.Route("GET", "/debug/pprof/symbol", wrapHandlerFunc(pprof.Symbol))
@@ -484,10 +1032,28 @@ func (s *Server) Handler() http.Handler {
 	return r.Handler()
 }
 
-func (s *Server) ListenAndServe(addr string) error {
-	return http.ListenAndServe(addr, s.Handler())
-}
-
 func formatCoordinate(c float64) string {
 	return strconv.FormatFloat(c, 'f', 6, 64)
 }
+
+// Summary returns the values of the CLI endpoints as "key: value" lines, keyed
+// by endpoint name. Empty values are omitted.
+func (r Response) Summary() string {
+	var sb strings.Builder
+	add := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(&sb, "%s: %s\n", key, value)
+		}
+	}
+	add("ip", r.IP.String())
+	add("country", r.Country)
+	add("country-iso", r.CountryISO)
+	add("city", r.City)
+	if r.Latitude != 0 || r.Longitude != 0 {
+		add("coordinates", formatCoordinate(r.Latitude)+","+formatCoordinate(r.Longitude))
+	}
+	add("asn", r.ASN)
+	add("asn-org", r.ASNOrg)
+	add("hostname", r.Hostname)
+	return sb.String()
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// ListenAndServeHTTP3 listens on the UDP address addr and serves HTTP/3 using
+// config until Shutdown is called, in which case http.ErrServerClosed is
+// returned. Responses served over TLS advertise the listener in the Alt-Svc
+// header.
+func (s *Server) ListenAndServeHTTP3(addr string, config *tls.Config) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	info := Listener{Network: "udp", Address: pc.LocalAddr().String()}
+	srv := &http3.Server{
+		Handler:        s.Handler(),
+		TLSConfig:      config,
+		IdleTimeout:    s.Timeouts.Idle,
+		MaxHeaderBytes: s.MaxHeaderBytes,
+		ConnContext: func(ctx context.Context, c *quic.Conn) context.Context {
+			return context.WithValue(ctx, listenerKey{}, &info)
+		},
+	}
+	if !s.addServer(srv) {
+		return http.ErrServerClosed
+	}
+	s.addAltSvc(pc.LocalAddr().(*net.UDPAddr).Port)
+	return srv.Serve(pc)
+}
+
+// addAltSvc adds an HTTP/3 listener on port to the Alt-Svc header.
+func (s *Server) addAltSvc(port int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	altSvc := fmt.Sprintf("h3=\":%d\"; ma=86400", port)
+	if s.altSvc != "" {
+		altSvc = s.altSvc + ", " + altSvc
+	}
+	s.altSvc = altSvc
+}
+
+// altSvcHandler sets the Alt-Svc header advertising HTTP/3 listeners, if any.
+func (s *Server) altSvcHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		altSvc := s.altSvc
+		s.mu.Unlock()
+		if altSvc != "" {
+			w.Header().Set("Alt-Svc", altSvc)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// protocolFromRequest returns the negotiated protocol of r, using the ALPN
+// identifiers of HTTP/2 and HTTP/3.
+func protocolFromRequest(r *http.Request) string {
+	switch r.ProtoMajor {
+	case 3:
+		return "h3"
+	case 2:
+		return "h2"
+	}
+	return r.Proto
+}
@@ -0,0 +1,92 @@
+package http
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestListenAndServeHTTP3(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "localhost")
+	certs, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{GetCertificate: certs.GetCertificate}
+	s := testServer()
+	addr := freeAddr(t)
+	errCh := make(chan error, 2)
+	go func() { errCh <- s.ListenAndServeTLS("tcp", addr, config) }()
+	go func() { errCh <- s.ListenAndServeHTTP3("127.0.0.1:0", config) }()
+
+	// The HTTP/3 listener is advertised on TLS responses
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	altSvc := regexp.MustCompile(`^h3=":(\d+)"; ma=86400$`)
+	var port string
+	for i := 0; ; i++ {
+		res, err := client.Get("https://" + addr + "/protocol")
+		if err == nil {
+			res.Body.Close()
+			if m := altSvc.FindStringSubmatch(res.Header.Get("Alt-Svc")); m != nil {
+				port = m[1]
+				break
+			}
+		}
+		if i == 50 {
+			t.Fatalf("no Alt-Svc header advertising HTTP/3 (error: %v)", err)
+		}
+		select {
+		case err := <-errCh:
+			t.Fatal(err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer transport.Close()
+	h3Client := &http.Client{Transport: transport}
+	h3Addr := net.JoinHostPort("127.0.0.1", port)
+	get := func(path string) string {
+		res, err := h3Client.Get("https://" + h3Addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if got := get("/protocol"); got != "h3\n" {
+		t.Errorf("want protocol %q, got %q", "h3\n", got)
+	}
+	var response Response
+	if err := json.Unmarshal([]byte(get("/json")), &response); err != nil {
+		t.Fatal(err)
+	}
+	want := Listener{Network: "udp", Address: h3Addr, Family: "ipv4"}
+	if response.Protocol != "h3" || response.Listener == nil || *response.Listener != want {
+		t.Errorf("want protocol h3 on listener %+v, got %q on %+v", want, response.Protocol, response.Listener)
+	}
+
+	if err := s.Shutdown(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errCh; err != http.ErrServerClosed {
+			t.Errorf("want %s, got %v", http.ErrServerClosed, err)
+		}
+	}
+}
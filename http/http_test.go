@@ -1,6 +1,12 @@
 package http
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
@@ -8,13 +14,27 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/mpolden/echoip/iputil"
 	"github.com/mpolden/echoip/iputil/geo"
 )
 
-func lookupAddr(net.IP) (string, error) { return "localhost", nil }
-func lookupPort(net.IP, uint64) error   { return nil }
+func lookupAddr(context.Context, net.IP) ([]string, error) { return []string{"localhost"}, nil }
+
+func lookupIP(context.Context, string) ([]net.IP, error) {
+	return []net.IP{net.ParseIP("127.0.0.1")}, nil
+}
+
+func lookupPort(_ context.Context, _ net.IP, port uint64) error {
+	if port == 8001 {
+		return fmt.Errorf("connection refused")
+	}
+	return nil
+}
 
 type testDb struct{}
 type ipTestCase struct {
@@ -25,22 +45,23 @@ type ipTestCase struct {
 	out            string
 }
 
-func (t *testDb) Country(net.IP) (geo.Country, error) {
+func (t *testDb) Country(context.Context, net.IP) (geo.Country, error) {
 	return geo.Country{Name: "Elbonia", ISO: "EB"}, nil
 }
 
-func (t *testDb) City(net.IP) (geo.City, error) {
+func (t *testDb) City(context.Context, net.IP) (geo.City, error) {
 	return geo.City{Name: "Bornyasherk", RegionName: "North Elbonia", RegionCode: "1234", MetroCode: 1234, PostalCode: "1234", Latitude: 63.416667, Longitude: 10.416667, Timezone: "Europe/Bornyasherk"}, nil
 }
 
-func (t *testDb) ASN(net.IP) (geo.ASN, error) {
-	return geo.ASN{AutonomousSystemNumber: 59795, AutonomousSystemOrganization: "Hosting4Real"}, nil
+func (t *testDb) ASN(context.Context, net.IP) (geo.ASN, error) {
+	_, network, _ := net.ParseCIDR("127.0.0.0/8")
+	return geo.ASN{AutonomousSystemNumber: 59795, AutonomousSystemOrganization: "Hosting4Real", Network: network}, nil
 }
 
 func (t *testDb) IsEmpty() bool { return false }
 
 func testServer() *Server {
-	return &Server{cache: NewCache(100), gr: &testDb{}, LookupAddr: lookupAddr, LookupPort: lookupPort}
+	return &Server{cache: NewCache(100), gr: &testDb{}, LookupAddr: lookupAddr, LookupIP: lookupIP, LookupPort: lookupPort, PortAllowPrivate: true}
 }
 
 func httpGet(url string, acceptMediaType string, userAgent string) (string, int, error) {
@@ -102,6 +123,9 @@ func TestCLIHandlers(t *testing.T) {
 		{s.URL + "/foo", "404 page not found", 404, "", ""},
 		{s.URL + "/asn", "AS59795\n", 200, "", ""},
 		{s.URL + "/asn-org", "Hosting4Real\n", 200, "", ""},
+		{s.URL + "/hostname", "localhost\n", 200, "", ""},
+		{s.URL + "/protocol", "HTTP/1.1\n", 200, "", ""},
+		{s.URL + "/port/8000-8002", "8000 reachable\n8001 unreachable\n8002 reachable\n", 200, "curl/7.43.0", ""},
 	}
 
 	for _, tt := range tests {
@@ -118,6 +142,24 @@ func TestCLIHandlers(t *testing.T) {
 	}
 }
 
+func TestSummary(t *testing.T) {
+	response, err := testServer().Lookup(context.Background(), net.ParseIP("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ip: 127.0.0.1\n" +
+		"country: Elbonia\n" +
+		"country-iso: EB\n" +
+		"city: Bornyasherk\n" +
+		"coordinates: 63.416667,10.416667\n" +
+		"asn: AS59795\n" +
+		"asn-org: Hosting4Real\n" +
+		"hostname: localhost\n"
+	if got := response.Summary(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
 func TestDisabledHandlers(t *testing.T) {
 	log.SetOutput(ioutil.Discard)
 	server := testServer()
@@ -135,7 +177,8 @@ func TestDisabledHandlers(t *testing.T) {
 		{s.URL + "/country", "404 page not found", 404},
 		{s.URL + "/country-iso", "404 page not found", 404},
 		{s.URL + "/city", "404 page not found", 404},
-		{s.URL + "/json", "{\n  \"ip\": \"127.0.0.1\",\n  \"ip_decimal\": 2130706433,\n  \"country_eu\": false\n}", 200},
+		{s.URL + "/hostname", "404 page not found", 404},
+		{s.URL + "/json", "{\n  \"ip\": \"127.0.0.1\",\n  \"ip_decimal\": 2130706433,\n  \"country_eu\": false,\n  \"protocol\": \"HTTP/1.1\"\n}", 200},
 	}
 
 	for _, tt := range tests {
@@ -161,13 +204,15 @@ func TestJSONHandlers(t *testing.T) {
 		out    string
 		status int
 	}{
-		{s.URL, "{\n  \"ip\": \"127.0.0.1\",\n  \"ip_decimal\": 2130706433,\n  \"country\": \"Elbonia\",\n  \"country_iso\": \"EB\",\n  \"country_eu\": false,\n  \"region_name\": \"North Elbonia\",\n  \"region_code\": \"1234\",\n  \"metro_code\": 1234,\n  \"zip_code\": \"1234\",\n  \"city\": \"Bornyasherk\",\n  \"latitude\": 63.416667,\n  \"longitude\": 10.416667,\n  \"time_zone\": \"Europe/Bornyasherk\",\n  \"asn\": \"AS59795\",\n  \"asn_org\": \"Hosting4Real\",\n  \"hostname\": \"localhost\",\n  \"user_agent\": {\n    \"product\": \"curl\",\n    \"version\": \"7.2.6.0\",\n    \"raw_value\": \"curl/7.2.6.0\"\n  }\n}", 200},
+		{s.URL, "{\n  \"ip\": \"127.0.0.1\",\n  \"ip_decimal\": 2130706433,\n  \"country\": \"Elbonia\",\n  \"country_iso\": \"EB\",\n  \"country_eu\": false,\n  \"region_name\": \"North Elbonia\",\n  \"region_code\": \"1234\",\n  \"metro_code\": 1234,\n  \"zip_code\": \"1234\",\n  \"city\": \"Bornyasherk\",\n  \"latitude\": 63.416667,\n  \"longitude\": 10.416667,\n  \"time_zone\": \"Europe/Bornyasherk\",\n  \"asn\": \"AS59795\",\n  \"asn_org\": \"Hosting4Real\",\n  \"network\": \"127.0.0.0/8\",\n  \"hostname\": \"localhost\",\n  \"hostnames\": [\n    \"localhost\"\n  ],\n  \"hostname_verified\": true,\n  \"user_agent\": {\n    \"product\": \"curl\",\n    \"version\": \"7.2.6.0\",\n    \"raw_value\": \"curl/7.2.6.0\",\n    \"browser\": \"curl\",\n    \"browser_version\": \"7.2.6.0\"\n  },\n  \"protocol\": \"HTTP/1.1\"\n}", 200},
 		{s.URL + "/port/foo", "{\n  \"status\": 400,\n  \"error\": \"invalid port: foo\"\n}", 400},
 		{s.URL + "/port/0", "{\n  \"status\": 400,\n  \"error\": \"invalid port: 0\"\n}", 400},
 		{s.URL + "/port/65537", "{\n  \"status\": 400,\n  \"error\": \"invalid port: 65537\"\n}", 400},
 		{s.URL + "/port/31337", "{\n  \"ip\": \"127.0.0.1\",\n  \"port\": 31337,\n  \"reachable\": true\n}", 200},
 		{s.URL + "/port/80", "{\n  \"ip\": \"127.0.0.1\",\n  \"port\": 80,\n  \"reachable\": true\n}", 200},            // checking that our test server is reachable on port 80
 		{s.URL + "/port/80?ip=1.3.3.7", "{\n  \"ip\": \"127.0.0.1\",\n  \"port\": 80,\n  \"reachable\": true\n}", 200}, // ensuring that the "ip" parameter is not usable to check remote host ports
+		{s.URL + "/port/80,443", "[\n  {\n    \"ip\": \"127.0.0.1\",\n    \"port\": 80,\n    \"reachable\": true\n  },\n  {\n    \"ip\": \"127.0.0.1\",\n    \"port\": 443,\n    \"reachable\": true\n  }\n]", 200},
+		{s.URL + "/port/1-100", "{\n  \"status\": 400,\n  \"error\": \"too many ports: at most 16 ports can be checked at once\"\n}", 400},
 		{s.URL + "/foo", "{\n  \"status\": 404,\n  \"error\": \"404 page not found\"\n}", 404},
 		{s.URL + "/health", `{"status":"OK"}`, 200},
 	}
@@ -312,3 +357,391 @@ func TestCLIMatcher(t *testing.T) {
 		}
 	}
 }
+
+func TestLookupTimeout(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	server := testServer()
+	server.LookupTimeout = 10 * time.Millisecond
+	server.LookupAddr = func(ctx context.Context, ip net.IP) ([]string, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	s := httptest.NewServer(server.Handler())
+
+	out, status, err := httpGet(s.URL+"/json", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 200 {
+		t.Errorf("Expected %d, got %d", 200, status)
+	}
+	if strings.Contains(out, "hostname") {
+		t.Errorf("Expected hostname to be omitted, got %q", out)
+	}
+	if !strings.Contains(out, `"country": "Elbonia"`) {
+		t.Errorf("Expected country in %q", out)
+	}
+	if _, ok := server.cache.Get(net.ParseIP("127.0.0.1")); ok {
+		t.Errorf("Expected incomplete response to not be cached")
+	}
+}
+
+func TestLookupHostname(t *testing.T) {
+	server := testServer()
+	server.LookupAddr = func(context.Context, net.IP) ([]string, error) {
+		return []string{"spoofed.example", "host.example"}, nil
+	}
+	server.LookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+		if host == "host.example" {
+			return []net.IP{net.ParseIP("192.0.2.2"), net.ParseIP("192.0.2.1")}, nil
+		}
+		return []net.IP{net.ParseIP("198.51.100.1")}, nil
+	}
+	var tests = []struct {
+		ip       string
+		hostname string
+		verified bool
+	}{
+		{"192.0.2.1", "host.example", true},
+		{"203.0.113.1", "spoofed.example", false},
+	}
+	for _, tt := range tests {
+		host := server.lookupHostname(context.Background(), net.ParseIP(tt.ip))
+		if host.name != tt.hostname {
+			t.Errorf("Expected hostname %q for %s, got %q", tt.hostname, tt.ip, host.name)
+		}
+		if len(host.names) != 2 {
+			t.Errorf("Expected all names for %s, got %v", tt.ip, host.names)
+		}
+		if host.verified == nil || *host.verified != tt.verified {
+			t.Errorf("Expected verified=%t for %s, got %v", tt.verified, tt.ip, host.verified)
+		}
+	}
+	server.LookupIP = nil
+	if host := server.lookupHostname(context.Background(), net.ParseIP("192.0.2.1")); host.verified != nil {
+		t.Errorf("Expected no verification without LookupIP, got %t", *host.verified)
+	}
+}
+
+func TestLookupHostnameLimit(t *testing.T) {
+	server := testServer()
+	server.LookupAddr = func(context.Context, net.IP) ([]string, error) {
+		names := make([]string, 10)
+		for i := range names {
+			names[i] = fmt.Sprintf("host%d.example", i)
+		}
+		return names, nil
+	}
+	var calls atomic.Int32
+	started := make(chan struct{})
+	server.LookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+		// Block until every name is being verified, which only happens if
+		// they are verified concurrently
+		if calls.Add(1) == maxVerifiedNames {
+			close(started)
+		}
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			return nil, errors.New("names not verified concurrently")
+		}
+		if host == "host3.example" || host == "host9.example" {
+			return []net.IP{net.ParseIP("192.0.2.1")}, nil
+		}
+		return nil, nil
+	}
+	host := server.lookupHostname(context.Background(), net.ParseIP("192.0.2.1"))
+	if want := "host3.example"; host.name != want {
+		t.Errorf("want hostname %q, got %q", want, host.name)
+	}
+	if host.verified == nil || !*host.verified {
+		t.Errorf("want verified hostname, got %v", host.verified)
+	}
+	if got := calls.Load(); got != maxVerifiedNames {
+		t.Errorf("want %d names verified, got %d", maxVerifiedNames, got)
+	}
+	if len(host.names) != 10 {
+		t.Errorf("want all names, got %v", host.names)
+	}
+}
+
+func TestPortProbe(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	server := testServer()
+	server.ProbeTLS = func(context.Context, net.IP, uint64) (tls.ConnectionState, error) {
+		cert := &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "example.com"},
+			DNSNames:    []string{"example.com", "www.example.com"},
+			IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+			NotAfter:    time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+		return tls.ConnectionState{Version: tls.VersionTLS13, NegotiatedProtocol: "h2", PeerCertificates: []*x509.Certificate{cert}}, nil
+	}
+	server.ProbeBanner = func(ctx context.Context, ip net.IP, port uint64, limit int) ([]byte, error) {
+		if port == 21 {
+			return nil, fmt.Errorf("i/o timeout")
+		}
+		return []byte("SSH-2.0-OpenSSH_9.6\r\n"), nil
+	}
+	s := httptest.NewServer(server.Handler())
+
+	var tests = []struct {
+		url    string
+		out    string
+		status int
+	}{
+		{s.URL + "/port/443?probe=tls", "{\n  \"ip\": \"127.0.0.1\",\n  \"port\": 443,\n  \"reachable\": true,\n  \"tls\": {\n    \"version\": \"TLS 1.3\",\n    \"alpn\": \"h2\",\n    \"subject\": \"CN=example.com\",\n    \"sans\": [\n      \"example.com\",\n      \"www.example.com\",\n      \"127.0.0.1\"\n    ],\n    \"not_after\": \"2030-01-01T00:00:00Z\"\n  }\n}", 200},
+		{s.URL + "/port/22?probe=banner", "{\n  \"ip\": \"127.0.0.1\",\n  \"port\": 22,\n  \"reachable\": true,\n  \"banner\": \"SSH-2.0-OpenSSH_9.6\"\n}", 200},
+		{s.URL + "/port/21?probe=banner", "{\n  \"ip\": \"127.0.0.1\",\n  \"port\": 21,\n  \"reachable\": true,\n  \"probe_error\": \"i/o timeout\"\n}", 200},
+		{s.URL + "/port/22?probe=foo", "{\n  \"status\": 400,\n  \"error\": \"invalid probe: foo\"\n}", 400},
+	}
+
+	for _, tt := range tests {
+		out, status, err := httpGet(tt.url, jsonMediaType, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("Expected %d for %s, got %d", tt.status, tt.url, status)
+		}
+		if out != tt.out {
+			t.Errorf("Expected %q for %s, got %q", tt.out, tt.url, out)
+		}
+	}
+
+	// Plain text includes the outcome of the probe
+	var textTests = []struct {
+		url string
+		out string
+	}{
+		{s.URL + "/port/21-22?probe=banner", "21 reachable probe_error=\"i/o timeout\"\n22 reachable banner=\"SSH-2.0-OpenSSH_9.6\"\n"},
+		{s.URL + "/port/443,8001?probe=tls", "443 reachable tls=\"TLS 1.3\" alpn=\"h2\" subject=\"CN=example.com\"\n8001 unreachable\n"},
+	}
+	for _, tt := range textTests {
+		out, _, err := httpGet(tt.url, "", "curl/7.43.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != tt.out {
+			t.Errorf("Expected %q for %s, got %q", tt.out, tt.url, out)
+		}
+	}
+}
+
+func TestUDPPortHandler(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	server := testServer()
+	var payloads []string
+	var mu sync.Mutex
+	server.UDPPayload = "ping"
+	server.LookupUDPPort = func(ctx context.Context, ip net.IP, port uint64, payload []byte) (string, error) {
+		mu.Lock()
+		payloads = append(payloads, string(payload))
+		mu.Unlock()
+		switch port {
+		case 53:
+			return iputil.UDPOpen, nil
+		case 54:
+			return iputil.UDPClosed, nil
+		}
+		return iputil.UDPOpenFiltered, nil
+	}
+	s := httptest.NewServer(server.Handler())
+
+	var tests = []struct {
+		url       string
+		out       string
+		status    int
+		userAgent string
+	}{
+		{s.URL + "/port/udp/53", "{\n  \"ip\": \"127.0.0.1\",\n  \"port\": 53,\n  \"protocol\": \"udp\",\n  \"reachable\": true,\n  \"state\": \"open\"\n}", 200, ""},
+		{s.URL + "/port/udp/51820?probe=wireguard", "{\n  \"ip\": \"127.0.0.1\",\n  \"port\": 51820,\n  \"protocol\": \"udp\",\n  \"reachable\": false,\n  \"state\": \"open|filtered\"\n}", 200, ""},
+		{s.URL + "/port/udp/53-55", "53 open\n54 closed\n55 open|filtered\n", 200, "curl/7.43.0"},
+		{s.URL + "/port/udp/53?probe=tls", "{\n  \"status\": 400,\n  \"error\": \"invalid probe: tls\"\n}", 400, ""},
+		{s.URL + "/port/53?probe=dns", "{\n  \"status\": 400,\n  \"error\": \"invalid probe: dns\"\n}", 400, ""},
+		{s.URL + "/port/tcp/53", "{\n  \"status\": 400,\n  \"error\": \"invalid port: tcp/53\"\n}", 400, ""},
+	}
+
+	for _, tt := range tests {
+		out, status, err := httpGet(tt.url, "", tt.userAgent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("Expected %d for %s, got %d", tt.status, tt.url, status)
+		}
+		if out != tt.out {
+			t.Errorf("Expected %q for %s, got %q", tt.out, tt.url, out)
+		}
+	}
+	if len(payloads) == 0 || payloads[0] != "ping" {
+		t.Errorf("Expected configured payload to be sent, got %q", payloads)
+	}
+	if len(payloads) < 2 || len(payloads[1]) != 148 {
+		t.Errorf("Expected WireGuard probe to be sent, got %q", payloads)
+	}
+}
+
+func TestPortAbuseControls(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	server := testServer()
+	server.IPHeaders = []string{"X-Real-IP"}
+	server.PortAllowPrivate = false
+	server.PortDeny = []uint64{25}
+	server.PortRateLimit = 3
+	s := httptest.NewServer(server.Handler())
+
+	var tests = []struct {
+		ip         string
+		url        string
+		out        string
+		status     int
+		retryAfter string
+	}{
+		{"127.0.0.1", "/port/80", "{\n  \"status\": 403,\n  \"error\": \"refusing to check ports of non-public address: 127.0.0.1\"\n}", 403, ""},
+		{"192.168.1.1", "/port/80", "{\n  \"status\": 403,\n  \"error\": \"refusing to check ports of non-public address: 192.168.1.1\"\n}", 403, ""},
+		{"192.0.2.1", "/port/25", "{\n  \"status\": 403,\n  \"error\": \"port not allowed: 25\"\n}", 403, ""},
+		{"192.0.2.1", "/port/80,443", "[\n  {\n    \"ip\": \"192.0.2.1\",\n    \"port\": 80,\n    \"reachable\": true\n  },\n  {\n    \"ip\": \"192.0.2.1\",\n    \"port\": 443,\n    \"reachable\": true\n  }\n]", 200, ""},
+		{"192.0.2.1", "/port/22", "{\n  \"ip\": \"192.0.2.1\",\n  \"port\": 22,\n  \"reachable\": true\n}", 200, ""},
+		{"192.0.2.1", "/port/22", "{\n  \"status\": 429,\n  \"error\": \"too many port checks, please try again later\"\n}", 429, "20"},
+		{"192.0.2.2", "/port/22", "{\n  \"ip\": \"192.0.2.2\",\n  \"port\": 22,\n  \"reachable\": true\n}", 200, ""},
+		// IPv6 clients are limited by their /64 prefix
+		{"2001:db8::1", "/port/80,443", "[\n  {\n    \"ip\": \"2001:db8::1\",\n    \"port\": 80,\n    \"reachable\": true\n  },\n  {\n    \"ip\": \"2001:db8::1\",\n    \"port\": 443,\n    \"reachable\": true\n  }\n]", 200, ""},
+		{"2001:db8::2", "/port/22", "{\n  \"ip\": \"2001:db8::2\",\n  \"port\": 22,\n  \"reachable\": true\n}", 200, ""},
+		{"2001:db8::3", "/port/22", "{\n  \"status\": 429,\n  \"error\": \"too many port checks, please try again later\"\n}", 429, "20"},
+		{"2001:db8:0:1::1", "/port/22", "{\n  \"ip\": \"2001:db8:0:1::1\",\n  \"port\": 22,\n  \"reachable\": true\n}", 200, ""},
+		// Requests for more ports than the limit are never allowed
+		{"192.0.2.3", "/port/80-83", "{\n  \"status\": 429,\n  \"error\": \"too many ports: at most 3 can be checked per minute\"\n}", 429, ""},
+	}
+
+	for _, tt := range tests {
+		r, err := http.NewRequest("GET", s.URL+tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("X-Real-IP", tt.ip)
+		r.Header.Set("Accept", jsonMediaType)
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.status {
+			t.Errorf("Expected %d for %s from %s, got %d", tt.status, tt.url, tt.ip, res.StatusCode)
+		}
+		if out := string(data); out != tt.out {
+			t.Errorf("Expected %q for %s from %s, got %q", tt.out, tt.url, tt.ip, out)
+		}
+		if got := res.Header.Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("Expected Retry-After %q for %s from %s, got %q", tt.retryAfter, tt.url, tt.ip, got)
+		}
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	server := testServer()
+	server.LookupHTTP = func(ctx context.Context, ip net.IP, port uint64) (iputil.HTTPResult, error) {
+		if port == 8080 {
+			return iputil.HTTPResult{}, fmt.Errorf("connection refused")
+		}
+		return iputil.HTTPResult{StatusCode: 301, ResponseTime: 42 * time.Millisecond, Server: "nginx", Location: "https://example.com/", TLSRequired: port == 443}, nil
+	}
+	s := httptest.NewServer(server.Handler())
+
+	var tests = []struct {
+		url    string
+		out    string
+		status int
+	}{
+		{s.URL + "/http/80", "{\n  \"ip\": \"127.0.0.1\",\n  \"port\": 80,\n  \"reachable\": true,\n  \"status_code\": 301,\n  \"response_time_ms\": 42,\n  \"server\": \"nginx\",\n  \"location\": \"https://example.com/\",\n  \"tls_required\": false\n}", 200},
+		{s.URL + "/http/443", "{\n  \"ip\": \"127.0.0.1\",\n  \"port\": 443,\n  \"reachable\": true,\n  \"status_code\": 301,\n  \"response_time_ms\": 42,\n  \"server\": \"nginx\",\n  \"location\": \"https://example.com/\",\n  \"tls_required\": true\n}", 200},
+		{s.URL + "/http/8080", "{\n  \"ip\": \"127.0.0.1\",\n  \"port\": 8080,\n  \"reachable\": false,\n  \"tls_required\": false,\n  \"error\": \"connection refused\"\n}", 200},
+		{s.URL + "/http/80?ip=1.3.3.7", "{\n  \"ip\": \"127.0.0.1\",\n  \"port\": 80,\n  \"reachable\": true,\n  \"status_code\": 301,\n  \"response_time_ms\": 42,\n  \"server\": \"nginx\",\n  \"location\": \"https://example.com/\",\n  \"tls_required\": false\n}", 200},
+		{s.URL + "/http/80,443", "{\n  \"status\": 400,\n  \"error\": \"invalid port: 80,443\"\n}", 400},
+	}
+
+	for _, tt := range tests {
+		out, status, err := httpGet(tt.url, jsonMediaType, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("Expected %d for %s, got %d", tt.status, tt.url, status)
+		}
+		if out != tt.out {
+			t.Errorf("Expected %q for %s, got %q", tt.out, tt.url, out)
+		}
+	}
+}
+
+func TestRateLimitedHandlers(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	server := testServer()
+	server.IPHeaders = []string{"X-Real-IP"}
+	server.RateLimits = map[string]RateLimit{
+		"cli":  {Rate: 1, Burst: 2},
+		"json": {Rate: 1, Burst: 1},
+	}
+	_, exempt, _ := net.ParseCIDR("198.51.100.0/24")
+	server.RateLimitExempt = []*net.IPNet{exempt}
+	s := httptest.NewServer(server.Handler())
+
+	var tests = []struct {
+		ip        string
+		url       string
+		accept    string
+		out       string
+		status    int
+		remaining string
+	}{
+		{"192.0.2.1", "/ip", "", "192.0.2.1\n", 200, "1"},
+		{"192.0.2.1", "/country", "", "Elbonia\n", 200, "0"},
+		{"192.0.2.1", "/city", "", "Too many requests, please try again later\n", 429, "0"},
+		{"192.0.2.1", "/json", "", "", 200, "0"},
+		{"192.0.2.1", "/json", "", "{\n  \"status\": 429,\n  \"error\": \"Too many requests, please try again later\"\n}", 429, "0"},
+		{"192.0.2.1", "/port/80", jsonMediaType, "{\n  \"ip\": \"192.0.2.1\",\n  \"port\": 80,\n  \"reachable\": true\n}", 200, ""},
+		{"2001:db8::1", "/ip", "", "2001:db8::1\n", 200, "1"},
+		{"2001:db8::2", "/ip", "", "2001:db8::2\n", 200, "0"},
+		{"2001:db8:0:1::1", "/ip", "", "2001:db8:0:1::1\n", 200, "1"},
+		{"198.51.100.1", "/ip", "", "198.51.100.1\n", 200, ""},
+		{"198.51.100.1", "/ip", "", "198.51.100.1\n", 200, ""},
+		{"198.51.100.1", "/ip", "", "198.51.100.1\n", 200, ""},
+	}
+
+	for _, tt := range tests {
+		r, err := http.NewRequest("GET", s.URL+tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("X-Real-IP", tt.ip)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.status {
+			t.Errorf("Expected %d for %s from %s, got %d", tt.status, tt.url, tt.ip, res.StatusCode)
+		}
+		if out := string(data); tt.out != "" && out != tt.out {
+			t.Errorf("Expected %q for %s from %s, got %q", tt.out, tt.url, tt.ip, out)
+		}
+		if got := res.Header.Get("RateLimit-Remaining"); got != tt.remaining {
+			t.Errorf("Expected RateLimit-Remaining %q for %s from %s, got %q", tt.remaining, tt.url, tt.ip, got)
+		}
+		if tt.status == 429 && res.Header.Get("Retry-After") != "1" {
+			t.Errorf("Expected Retry-After for %s from %s, got %q", tt.url, tt.ip, res.Header.Get("Retry-After"))
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Listener describes the listener a request arrived on.
+type Listener struct {
+	// Network is the network of the listener: tcp, tcp4, tcp6 or unix.
+	Network string `json:"network"`
+	// Address is the listening address.
+	Address string `json:"address"`
+	// Family is the address family of the connection: ipv4 or ipv6. It is
+	// empty for Unix sockets.
+	Family string `json:"family,omitempty"`
+}
+
+type listenerKey struct{}
+
+// boundListener is a net.Listener along with the description of it reported in
+// responses.
+type boundListener struct {
+	net.Listener
+	info Listener
+}
+
+// ParseListenAddr parses a listening address, optionally prefixed by the network
+// to listen on. The network is one of:
+//
+//   - tcp, tcp4 or tcp6, such as "tcp4::8080" or "tcp6:[::1]:8080". The network
+//     defaults to tcp, which listens on both IPv4 and IPv6.
+//   - unix, such as "unix:/run/echoip.sock".
+//   - systemd, which uses the sockets passed by systemd socket activation. An
+//     optional name selects the sockets with the given FileDescriptorName, such
+//     as "systemd:http".
+func ParseListenAddr(s string) (network, addr string) {
+	for _, network := range []string{"tcp4", "tcp6", "tcp"} {
+		if addr, ok := strings.CutPrefix(s, network+":"); ok && strings.Contains(addr, ":") {
+			return network, addr
+		}
+	}
+	if addr, ok := strings.CutPrefix(s, "unix:"); ok {
+		return "unix", addr
+	}
+	if s == "systemd" {
+		return "systemd", ""
+	}
+	if addr, ok := strings.CutPrefix(s, "systemd:"); ok {
+		return "systemd", addr
+	}
+	return "tcp", s
+}
+
+func (s *Server) listen(network, addr string) ([]boundListener, error) {
+	switch network {
+	case "unix":
+		l, err := listenUnix(addr, s.UnixSocketMode, s.UnixSocketGroup)
+		if err != nil {
+			return nil, err
+		}
+		return []boundListener{{l, Listener{Network: network, Address: addr}}}, nil
+	case "systemd":
+		ls, err := listenSystemd(addr)
+		if err != nil {
+			return nil, err
+		}
+		listeners := make([]boundListener, 0, len(ls))
+		for _, l := range ls {
+			info := Listener{Network: l.Addr().Network(), Address: l.Addr().String()}
+			listeners = append(listeners, boundListener{l, info})
+		}
+		return listeners, nil
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return []boundListener{{l, Listener{Network: network, Address: addr}}}, nil
+}
+
+// listenUnix listens on the Unix socket at path, replacing any stale socket
+// left behind by a previous process. If mode is non-zero, the permissions of
+// the socket are set to mode. If group is non-empty, the group of the socket is
+// set to group, given as either a name or numeric ID.
+func listenUnix(path string, mode os.FileMode, group string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("listen unix %s: socket is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if group != "" {
+		gid, err := lookupGID(group)
+		if err == nil {
+			err = os.Chown(path, -1, gid)
+		}
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func lookupGID(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// The first file descriptor passed by systemd
+const systemdFirstFD = 3
+
+var systemd struct {
+	once      sync.Once
+	mu        sync.Mutex
+	listeners []systemdListener
+	err       error
+}
+
+type systemdListener struct {
+	name string
+	net.Listener
+	used bool
+}
+
+// listenSystemd returns the sockets passed by systemd with the given name, or
+// all unused sockets if name is empty.
+func listenSystemd(name string) ([]net.Listener, error) {
+	systemd.once.Do(func() {
+		var files []*os.File
+		files, systemd.err = systemdFiles(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"))
+		if systemd.err == nil {
+			systemd.listeners, systemd.err = systemdListeners(files)
+		}
+		// Prevent child processes from inheriting the sockets
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	})
+	if systemd.err != nil {
+		return nil, systemd.err
+	}
+	systemd.mu.Lock()
+	defer systemd.mu.Unlock()
+	return takeSystemdListeners(systemd.listeners, name)
+}
+
+func takeSystemdListeners(listeners []systemdListener, name string) ([]net.Listener, error) {
+	var ls []net.Listener
+	for i := range listeners {
+		l := &listeners[i]
+		if l.used || (name != "" && l.name != name) {
+			continue
+		}
+		l.used = true
+		ls = append(ls, l.Listener)
+	}
+	if len(ls) == 0 {
+		if name != "" {
+			return nil, fmt.Errorf("no socket named %q passed by systemd", name)
+		}
+		return nil, errors.New("no sockets passed by systemd")
+	}
+	return ls, nil
+}
+
+// systemdFiles returns the file descriptors passed by systemd, as described by
+// sd_listen_fds(3).
+func systemdFiles(pid, fds, names string) ([]*os.File, error) {
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, errors.New("no sockets passed by systemd")
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %q", fds)
+	}
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+	}
+	files := make([]*os.File, 0, n)
+	for i := 0; i < n; i++ {
+		fd := systemdFirstFD + i
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(fdNames) {
+			name = fdNames[i]
+		}
+		files = append(files, os.NewFile(uintptr(fd), name))
+	}
+	return files, nil
+}
+
+// systemdListeners creates listeners from files, named by the file names. The
+// files are closed.
+func systemdListeners(files []*os.File) ([]systemdListener, error) {
+	listeners := make([]systemdListener, 0, len(files))
+	var err error
+	for _, f := range files {
+		if err == nil {
+			var l net.Listener
+			// FileListener duplicates the file descriptor, so the passed one
+			// can be closed
+			if l, err = net.FileListener(f); err != nil {
+				err = fmt.Errorf("systemd socket %s: %w", f.Name(), err)
+			} else {
+				listeners = append(listeners, systemdListener{name: f.Name(), Listener: l})
+			}
+		}
+		f.Close()
+	}
+	return listeners, err
+}
+
+// listenerFromRequest returns the listener r arrived on, if known.
+func listenerFromRequest(r *http.Request) *Listener {
+	l, ok := r.Context().Value(listenerKey{}).(*Listener)
+	if !ok {
+		return nil
+	}
+	listener := *l
+	var ip net.IP
+	switch addr := r.Context().Value(http.LocalAddrContextKey).(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	}
+	if ip.To4() != nil {
+		listener.Family = "ipv4"
+	} else if ip != nil {
+		listener.Family = "ipv6"
+	}
+	return &listener
+}
@@ -0,0 +1,261 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseListenAddr(t *testing.T) {
+	var tests = []struct {
+		in      string
+		network string
+		addr    string
+	}{
+		{":8080", "tcp", ":8080"},
+		{"127.0.0.1:8080", "tcp", "127.0.0.1:8080"},
+		{"[::1]:8080", "tcp", "[::1]:8080"},
+		{"tcp::8080", "tcp", ":8080"},
+		{"tcp4::8080", "tcp4", ":8080"},
+		{"tcp4:192.0.2.1:8080", "tcp4", "192.0.2.1:8080"},
+		{"tcp6:[::1]:8080", "tcp6", "[::1]:8080"},
+		{"tcp6:8080", "tcp", "tcp6:8080"}, // Host named tcp6
+		{"unix:/run/echoip.sock", "unix", "/run/echoip.sock"},
+		{"systemd", "systemd", ""},
+		{"systemd:http", "systemd", "http"},
+	}
+	for _, tt := range tests {
+		network, addr := ParseListenAddr(tt.in)
+		if network != tt.network || addr != tt.addr {
+			t.Errorf("ParseListenAddr(%q) = (%q, %q), want (%q, %q)", tt.in, network, addr, tt.network, tt.addr)
+		}
+	}
+}
+
+func TestListenerResponse(t *testing.T) {
+	var tests = []struct {
+		network string
+		host    string
+		family  string
+	}{
+		{"tcp4", "127.0.0.1", "ipv4"},
+		{"tcp6", "::1", "ipv6"},
+	}
+	s := testServer()
+	defer s.Shutdown(t.Context())
+	for _, tt := range tests {
+		l, err := net.Listen(tt.network, net.JoinHostPort(tt.host, "0"))
+		if err != nil {
+			t.Logf("skipping %s: %s", tt.network, err)
+			continue
+		}
+		addr := l.Addr().String()
+		l.Close()
+		go s.ListenAndServe(tt.network, addr)
+
+		var out string
+		for i := 0; ; i++ {
+			out, _, err = httpGet("http://"+addr+"/json", jsonMediaType, "curl/7.2.6.0")
+			if err == nil {
+				break
+			} else if i == 50 {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		var response Response
+		if err := json.Unmarshal([]byte(out), &response); err != nil {
+			t.Fatal(err)
+		}
+		want := Listener{Network: tt.network, Address: addr, Family: tt.family}
+		if response.Listener == nil || *response.Listener != want {
+			t.Errorf("want listener %+v, got %+v", want, response.Listener)
+		}
+	}
+}
+
+func TestUnixListener(t *testing.T) {
+	// Keep the path short, as socket paths are limited to around 100 bytes
+	dir, err := os.MkdirTemp("", "echoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "echoip.sock")
+
+	// Leave a stale socket behind
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	s := testServer()
+	s.IPHeaders = []string{"X-Real-IP"}
+	s.Template = "../html"
+	s.UnixSocketMode = 0600
+	s.UnixSocketGroup = strconv.Itoa(os.Getgid())
+	defer s.Shutdown(t.Context())
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.ListenAndServe("unix", path) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	get := func(path, realIP string) (*http.Response, string) {
+		r, err := http.NewRequest(http.MethodGet, "http://echoip"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if realIP != "" {
+			r.Header.Set("X-Real-IP", realIP)
+		}
+		r.Header.Set("User-Agent", "Mozilla/5.0")
+		for i := 0; ; i++ {
+			res, err := client.Do(r)
+			if err == nil {
+				defer res.Body.Close()
+				data, err := ioutil.ReadAll(res.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return res, string(data)
+			} else if i == 50 {
+				t.Fatal(err)
+			}
+			select {
+			case err := <-errCh:
+				t.Fatal(err)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	res, out := get("/json", "192.0.2.1")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("want status %d, got %d: %s", http.StatusOK, res.StatusCode, out)
+	}
+	var response Response
+	if err := json.Unmarshal([]byte(out), &response); err != nil {
+		t.Fatal(err)
+	}
+	if want := "192.0.2.1"; response.IP.String() != want {
+		t.Errorf("want IP %s, got %s", want, response.IP)
+	}
+	want := Listener{Network: "unix", Address: path}
+	if response.Listener == nil || *response.Listener != want {
+		t.Errorf("want listener %+v, got %+v", want, response.Listener)
+	}
+	if res, out := get("/json", ""); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("want status %d without trusted header, got %d: %s", http.StatusBadRequest, res.StatusCode, out)
+	}
+	// Unix sockets have no address family
+	if res, out := get("/", "192.0.2.1"); res.StatusCode != http.StatusOK {
+		t.Errorf("want status %d, got %d: %s", http.StatusOK, res.StatusCode, out)
+	} else if want := "<td>" + path + "</td>"; !strings.Contains(out, want) {
+		t.Errorf("want %q in page, got %s", want, out)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fi.Mode().Perm(); got != 0600 {
+		t.Errorf("want mode %s, got %s", os.FileMode(0600), got)
+	}
+
+	// The socket is in use
+	if _, err := listenUnix(path, 0, ""); err == nil {
+		t.Error("expected error when socket is in use")
+	}
+}
+
+func TestSystemdListeners(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	if _, err := systemdFiles("1", "2", ""); err == nil {
+		t.Error("expected error for other pid")
+	}
+	if _, err := systemdFiles(pid, "0", ""); err == nil {
+		t.Error("expected error for no file descriptors")
+	}
+
+	var files []*os.File
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		// The file is closed by systemdListeners
+		f, err := l.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+		addrs = append(addrs, l.Addr().String())
+	}
+	listeners, err := systemdListeners(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	for i, f := range files {
+		if listeners[i].name != f.Name() {
+			t.Errorf("want name %q, got %q", f.Name(), listeners[i].name)
+		}
+		if got := listeners[i].Addr().String(); got != addrs[i] {
+			t.Errorf("want address %s, got %s", addrs[i], got)
+		}
+	}
+
+	ls, err := takeSystemdListeners(listeners, files[1].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != 1 || ls[0].Addr().String() != addrs[1] {
+		t.Errorf("want listener on %s, got %v", addrs[1], ls)
+	}
+	if _, err := takeSystemdListeners(listeners, files[1].Name()); err == nil {
+		t.Error("expected error for listener already taken")
+	}
+	ls, err = takeSystemdListeners(listeners, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != 1 || ls[0].Addr().String() != addrs[0] {
+		t.Errorf("want listener on %s, got %v", addrs[0], ls)
+	}
+}
+
+func TestIPFromUnixRequest(t *testing.T) {
+	for _, remoteAddr := range []string{"@", ""} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		if _, err := ipFromRequest(nil, r, false); err == nil || !strings.Contains(err.Error(), "not an IP address") {
+			t.Errorf("RemoteAddr %q: want error, got %v", remoteAddr, err)
+		}
+		r.Header.Set("X-Real-IP", "192.0.2.1")
+		ip, err := ipFromRequest([]string{"X-Real-IP"}, r, false)
+		if err != nil || ip.String() != "192.0.2.1" {
+			t.Errorf("RemoteAddr %q: want 192.0.2.1, got (%s, %v)", remoteAddr, ip, err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrPortForbidden matches errors returned when checking the port or
+	// address is not allowed.
+	ErrPortForbidden = errors.New("port check forbidden")
+	// ErrPortRateLimited matches errors returned when the client has checked
+	// too many ports.
+	ErrPortRateLimited = errors.New("port check rate limited")
+)
+
+const (
+	defaultPortLimit       = 16
+	defaultPortConcurrency = 4
+)
+
+// parsePorts parses a comma-separated list of ports and port ranges, such as
+// "80,443,8000-8010". Duplicate ports are removed. An error is returned if the
+// list contains more than limit ports.
+func parsePorts(s string, limit int) ([]uint64, error) {
+	var ports []uint64
+	seen := make(map[uint64]bool)
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := parsePort(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port: %s", part)
+		}
+		end := start
+		if isRange {
+			end, err = parsePort(last)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid port range: %s", part)
+			}
+		}
+		if end-start >= uint64(limit) {
+			return nil, fmt.Errorf("too many ports: at most %d ports can be checked at once", limit)
+		}
+		for port := start; port <= end; port++ {
+			if seen[port] {
+				continue
+			}
+			if len(ports) == limit {
+				return nil, fmt.Errorf("too many ports: at most %d ports can be checked at once", limit)
+			}
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+func parsePort(s string) (uint64, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || port < 1 {
+		return 0, fmt.Errorf("invalid port: %s", s)
+	}
+	return port, nil
+}
+
+// ParsePorts parses a comma-separated list of ports and port ranges.
+func ParsePorts(s string) ([]uint64, error) {
+	return parsePorts(s, 65535)
+}
+
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPublic reports whether ip is a public unicast address, i.e. not a private,
+// loopback, link-local or carrier-grade NAT address.
+func isPublic(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// refusedError is returned when a port check is refused by one of the abuse
+// controls.
+type refusedError struct {
+	message    string
+	code       int
+	retryAfter time.Duration
+}
+
+func (e *refusedError) Error() string { return e.message }
+
+func (e *refusedError) Is(target error) bool {
+	switch target {
+	case ErrPortForbidden:
+		return e.code == http.StatusForbidden
+	case ErrPortRateLimited:
+		return e.code == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// dialLimiter limits the number of concurrent port checks, both per client IP
+// and in total.
+type dialLimiter struct {
+	mu      sync.Mutex
+	clients map[uint64]*dialSemaphore
+	global  chan struct{}
+}
+
+type dialSemaphore struct {
+	slots chan struct{}
+	refs  int
+}
+
+// acquire blocks until a dial slot is available for ip, or ctx is done. The
+// number of slots per client is given by limit, and the total number of slots
+// by globalLimit. A globalLimit of 0 means no limit. The returned function must
+// be called to release the slot.
+func (l *dialLimiter) acquire(ctx context.Context, ip net.IP, limit, globalLimit int) (func(), error) {
+	release, err := l.acquireClient(ctx, ip, limit)
+	if err != nil || globalLimit < 1 {
+		return release, err
+	}
+	l.mu.Lock()
+	if l.global == nil {
+		l.global = make(chan struct{}, globalLimit)
+	}
+	global := l.global
+	l.mu.Unlock()
+	select {
+	case global <- struct{}{}:
+		return func() {
+			<-global
+			release()
+		}, nil
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+}
+
+func (l *dialLimiter) acquireClient(ctx context.Context, ip net.IP, limit int) (func(), error) {
+	k := key(ip)
+	l.mu.Lock()
+	if l.clients == nil {
+		l.clients = make(map[uint64]*dialSemaphore)
+	}
+	sem, ok := l.clients[k]
+	if !ok {
+		sem = &dialSemaphore{slots: make(chan struct{}, limit)}
+		l.clients[k] = sem
+	}
+	sem.refs++
+	l.mu.Unlock()
+
+	done := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		sem.refs--
+		if sem.refs == 0 {
+			delete(l.clients, k)
+		}
+	}
+	select {
+	case sem.slots <- struct{}{}:
+		return func() {
+			<-sem.slots
+			done()
+		}, nil
+	case <-ctx.Done():
+		done()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePorts(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out []uint64
+		err string
+	}{
+		{"80", []uint64{80}, ""},
+		{"80,443,8443", []uint64{80, 443, 8443}, ""},
+		{"8000-8003", []uint64{8000, 8001, 8002, 8003}, ""},
+		{"22,8000-8002,22,8001", []uint64{22, 8000, 8001, 8002}, ""},
+		{"65535", []uint64{65535}, ""},
+		{"0", nil, "invalid port: 0"},
+		{"65536", nil, "invalid port: 65536"},
+		{"foo", nil, "invalid port: foo"},
+		{"80,", nil, "invalid port: "},
+		{"8010-8000", nil, "invalid port range: 8010-8000"},
+		{"8000-", nil, "invalid port range: 8000-"},
+		{"1-10", nil, "too many ports: at most 5 ports can be checked at once"},
+		{"1,2,3,4,5,6", nil, "too many ports: at most 5 ports can be checked at once"},
+		{"1-65535", nil, "too many ports: at most 5 ports can be checked at once"},
+	}
+	for _, tt := range tests {
+		ports, err := parsePorts(tt.in, 5)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("parsePorts(%q): got err %v, want %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ports, tt.out) {
+			t.Errorf("parsePorts(%q) = %v, want %v", tt.in, ports, tt.out)
+		}
+	}
+}
+
+func TestDialLimiter(t *testing.T) {
+	var l dialLimiter
+	ip := net.ParseIP("192.0.2.1")
+	ctx := context.Background()
+	release1, err := l.acquire(ctx, ip, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release2, err := l.acquire(ctx, ip, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Other clients are not affected
+	release3, err := l.acquire(ctx, net.ParseIP("192.0.2.2"), 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release3()
+	// Limit is reached for this client
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(timeoutCtx, ip, 2, 0); err != context.DeadlineExceeded {
+		t.Errorf("got err %v, want %v", err, context.DeadlineExceeded)
+	}
+	release1()
+	release3, err = l.acquire(ctx, ip, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release2()
+	release3()
+	if got := len(l.clients); got != 0 {
+		t.Errorf("got %d clients, want %d", got, 0)
+	}
+}
+
+func TestAcquirePortPrefix(t *testing.T) {
+	s := testServer()
+	s.PortConcurrency = 1
+	ctx := context.Background()
+	_, release, err := s.acquirePort(ctx, net.ParseIP("2001:db8::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	// Addresses in the same /64 share the limit
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := s.acquirePort(timeoutCtx, net.ParseIP("2001:db8::2")); err != context.DeadlineExceeded {
+		t.Errorf("got err %v, want %v", err, context.DeadlineExceeded)
+	}
+	_, release2, err := s.acquirePort(ctx, net.ParseIP("2001:db8:0:1::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	release2()
+}
+
+func TestDialLimiterGlobal(t *testing.T) {
+	var l dialLimiter
+	ctx := context.Background()
+	release, err := l.acquire(ctx, net.ParseIP("192.0.2.1"), 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(timeoutCtx, net.ParseIP("192.0.2.2"), 2, 1); err != context.DeadlineExceeded {
+		t.Errorf("got err %v, want %v", err, context.DeadlineExceeded)
+	}
+	release()
+	release, err = l.acquire(ctx, net.ParseIP("192.0.2.2"), 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestIsPublic(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out bool
+	}{
+		{"192.0.2.1", true},
+		{"2001:db8::1", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.0.0.1", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"100.64.0.1", false},
+		{"169.254.1.1", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := isPublic(net.ParseIP(tt.in)); got != tt.out {
+			t.Errorf("isPublic(%s) = %t, want %t", tt.in, got, tt.out)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package http
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket rate limiter keyed by client IP. Each client
+// starts with a full bucket of burst tokens, which is refilled at the given
+// rate per second.
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[uint64]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimitResult struct {
+	allowed    bool
+	remaining  int
+	retryAfter time.Duration
+	reset      time.Duration
+}
+
+// allow takes n tokens from the bucket of ip. If the bucket does not contain
+// enough tokens, none are taken and allow returns false and the duration until
+// enough tokens are available. Requests for more than burst tokens are never
+// allowed, and the returned duration is zero.
+func (l *rateLimiter) allow(ip net.IP, n int, rate, burst float64) (bool, time.Duration) {
+	result := l.take(ip, n, rate, burst)
+	return result.allowed, result.retryAfter
+}
+
+// take is like allow, but also returns the number of remaining tokens and the
+// duration until the bucket is full.
+func (l *rateLimiter) take(ip net.IP, n int, rate, burst float64) rateLimitResult {
+	now := time.Now()
+	if l.now != nil {
+		now = l.now()
+	}
+	k := key(ip)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buckets == nil {
+		l.buckets = make(map[uint64]*bucket)
+	}
+	l.sweep(now, rate, burst)
+	b, ok := l.buckets[k]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[k] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	want := float64(n)
+	result := rateLimitResult{allowed: b.tokens >= want}
+	if result.allowed {
+		b.tokens -= want
+	} else if want <= burst {
+		result.retryAfter = seconds((want - b.tokens) / rate)
+	}
+	result.remaining = int(b.tokens)
+	result.reset = seconds((burst - b.tokens) / rate)
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
+
+// formatSeconds formats d as a number of seconds, rounded up, for use in
+// Retry-After and RateLimit-* headers.
+func formatSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// sweep removes buckets that have been refilled, at most once per minute.
+func (l *rateLimiter) sweep(now time.Time, rate, burst float64) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// RateLimit configures the rate limit of a group of routes.
+type RateLimit struct {
+	// Rate is the number of requests per second allowed per client.
+	Rate float64
+	// Burst is the maximum number of requests allowed at once.
+	Burst int
+}
+
+// rateLimitKey returns the IP address used to identify the client: The IP
+// itself for IPv4, and its /64 prefix for IPv6.
+func rateLimitKey(ip net.IP) net.IP {
+	if ip.To4() != nil {
+		return ip
+	}
+	return ip.Mask(net.CIDRMask(64, 128))
+}
+
+// rateLimit returns the rate limit of the named group of routes, if configured.
+func (s *Server) rateLimit(group string) (RateLimit, bool) {
+	limit, ok := s.RateLimits[group]
+	return limit, ok && limit.Rate > 0 && limit.Burst >= 1
+}
+
+// limiter returns the rate limiter of the named group of routes, which is
+// shared by all listeners.
+func (s *Server) limiter(group string) *rateLimiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.limiters == nil {
+		s.limiters = make(map[string]*rateLimiter)
+	}
+	limiter, ok := s.limiters[group]
+	if !ok {
+		limiter = &rateLimiter{}
+		s.limiters[group] = limiter
+	}
+	return limiter
+}
+
+// takeRequest takes a request from the rate limit of ip in the named group. It
+// returns false if ip is exempt from rate limiting.
+func (s *Server) takeRequest(group string, limit RateLimit, ip net.IP) (rateLimitResult, bool) {
+	for _, network := range s.RateLimitExempt {
+		if network.Contains(ip) {
+			return rateLimitResult{}, false
+		}
+	}
+	return s.limiter(group).take(rateLimitKey(ip), 1, limit.Rate, float64(limit.Burst)), true
+}
+
+// AllowRequest counts a request from ip against the rate limit of the named
+// group of routes, for use by APIs other than HTTP. If the request exceeds the
+// limit, AllowRequest returns false and the duration until another request is
+// allowed.
+func (s *Server) AllowRequest(group string, ip net.IP) (bool, time.Duration) {
+	limit, ok := s.rateLimit(group)
+	if !ok {
+		return true, 0
+	}
+	result, limited := s.takeRequest(group, limit, ip)
+	if !limited {
+		return true, 0
+	}
+	return result.allowed, result.retryAfter
+}
+
+// rateLimited returns a function wrapping handlers in the rate limiter of the
+// named group of routes, if configured. All handlers in a group share the same
+// limit. Clients in RateLimitExempt are never limited.
+func (s *Server) rateLimited(group string) func(appHandler) appHandler {
+	limit, ok := s.rateLimit(group)
+	if !ok {
+		return func(handler appHandler) appHandler { return handler }
+	}
+	return func(handler appHandler) appHandler {
+		return s.rateLimitHandler(limit, group, handler)
+	}
+}
+
+func (s *Server) rateLimitHandler(limit RateLimit, group string, handler appHandler) appHandler {
+	return func(w http.ResponseWriter, r *http.Request) *appError {
+		ip, err := ipFromRequest(s.IPHeaders, r, false)
+		if err != nil {
+			return handler(w, r)
+		}
+		result, limited := s.takeRequest(group, limit, ip)
+		if !limited {
+			return handler(w, r)
+		}
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+		w.Header().Set("RateLimit-Reset", formatSeconds(result.reset))
+		if !result.allowed {
+			w.Header().Set("Retry-After", formatSeconds(result.retryAfter))
+			message := "Too many requests, please try again later"
+			if group != "cli" {
+				return tooManyRequests(nil).WithMessage(message).AsJSON()
+			}
+			// Plain text responses end with a newline, like other CLI responses
+			return tooManyRequests(nil).WithMessage(message + "\n")
+		}
+		return handler(w, r)
+	}
+}
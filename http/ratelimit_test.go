@@ -0,0 +1,63 @@
+package http
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := rateLimiter{now: func() time.Time { return now }}
+	ip := net.ParseIP("192.0.2.1")
+	// 1 token per second, burst of 3
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow(ip, 1, 1, 3); !ok {
+			t.Fatalf("#%d: expected request to be allowed", i)
+		}
+	}
+	ok, wait := l.allow(ip, 1, 1, 3)
+	if ok {
+		t.Fatal("expected request to be limited")
+	}
+	if wait != time.Second {
+		t.Errorf("got wait %s, want %s", wait, time.Second)
+	}
+	// Other clients have their own bucket
+	if ok, _ := l.allow(net.ParseIP("192.0.2.2"), 3, 1, 3); !ok {
+		t.Error("expected request from other client to be allowed")
+	}
+	now = now.Add(2 * time.Second)
+	if ok, _ := l.allow(ip, 2, 1, 3); !ok {
+		t.Error("expected request to be allowed after refill")
+	}
+	if ok, wait := l.allow(ip, 1, 1, 3); ok || wait != time.Second {
+		t.Errorf("got (%t, %s), want (%t, %s)", ok, wait, false, time.Second)
+	}
+	// Requests for more tokens than the burst are never allowed
+	if ok, wait := l.allow(net.ParseIP("192.0.2.3"), 4, 1, 3); ok || wait != 0 {
+		t.Errorf("got (%t, %s), want (%t, %s)", ok, wait, false, time.Duration(0))
+	}
+	// Buckets that have been refilled are removed
+	now = now.Add(time.Hour)
+	l.allow(ip, 1, 1, 3)
+	if got := len(l.buckets); got != 1 {
+		t.Errorf("got %d buckets, want %d", got, 1)
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{"192.0.2.1", "192.0.2.1"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::"},
+		{"2001:db8:1:2::1", "2001:db8:1:2::"},
+	}
+	for _, tt := range tests {
+		if got := rateLimitKey(net.ParseIP(tt.in)); !got.Equal(net.ParseIP(tt.out)) {
+			t.Errorf("rateLimitKey(%s) = %s, want %s", tt.in, got, tt.out)
+		}
+	}
+}
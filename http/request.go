@@ -0,0 +1,145 @@
+package http
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// RequestResponse describes a request as seen by the server.
+type RequestResponse struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Protocol   string      `json:"protocol"`
+	RemoteAddr string      `json:"remote_addr"`
+	TLS        *RequestTLS `json:"tls,omitempty"`
+	IP         net.IP      `json:"ip"`
+	IPSource   string      `json:"ip_source"`
+	IPHeaders  []IPHeader  `json:"ip_headers,omitempty"`
+	Headers    http.Header `json:"headers"`
+}
+
+// RequestTLS describes the TLS connection of a request.
+type RequestTLS struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	ServerName  string `json:"server_name,omitempty"`
+	ALPN        string `json:"alpn,omitempty"`
+	Resumed     bool   `json:"resumed"`
+}
+
+// IPHeader is a trusted header, in the order they are considered when
+// determining the client IP. Used is true for the header the IP was taken from.
+type IPHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+	Used  bool   `json:"used"`
+}
+
+// requestHeaders returns the headers of r, including Host, with the values of
+// RedactHeaders replaced.
+func (s *Server) requestHeaders(r *http.Request) http.Header {
+	headers := r.Header.Clone()
+	if r.Host != "" {
+		headers.Set("Host", r.Host)
+	}
+	for _, name := range s.RedactHeaders {
+		values := headers[http.CanonicalHeaderKey(name)]
+		for i := range values {
+			values[i] = redacted
+		}
+	}
+	return headers
+}
+
+// redacts returns true if the value of header name is redacted.
+func (s *Server) redacts(name string) bool {
+	return slices.ContainsFunc(s.RedactHeaders, func(v string) bool { return strings.EqualFold(v, name) })
+}
+
+func (s *Server) newRequestResponse(r *http.Request) (RequestResponse, error) {
+	ip, err := ipFromRequest(s.IPHeaders, r, false)
+	if err != nil {
+		return RequestResponse{}, err
+	}
+	_, source := ipFromHeaders(s.IPHeaders, r)
+	response := RequestResponse{
+		Method:     r.Method,
+		URL:        requestScheme(r) + "://" + r.Host + r.URL.RequestURI(),
+		Protocol:   protocolFromRequest(r),
+		RemoteAddr: r.RemoteAddr,
+		IP:         ip,
+		IPSource:   source,
+		Headers:    s.requestHeaders(r),
+	}
+	if source == "" {
+		response.IPSource = "remote_addr"
+	}
+	for _, name := range s.IPHeaders {
+		value := r.Header.Get(name)
+		if value != "" && s.redacts(name) {
+			value = redacted
+		}
+		response.IPHeaders = append(response.IPHeaders, IPHeader{
+			Name:  http.CanonicalHeaderKey(name),
+			Value: value,
+			Used:  name == source,
+		})
+	}
+	if r.TLS != nil {
+		response.TLS = &RequestTLS{
+			Version:     tls.VersionName(r.TLS.Version),
+			CipherSuite: tls.CipherSuiteName(r.TLS.CipherSuite),
+			ServerName:  r.TLS.ServerName,
+			ALPN:        r.TLS.NegotiatedProtocol,
+			Resumed:     r.TLS.DidResume,
+		}
+	}
+	return response, nil
+}
+
+// HeadersHandler writes the request headers, as plain text or JSON.
+func (s *Server) HeadersHandler(w http.ResponseWriter, r *http.Request) *appError {
+	headers := s.requestHeaders(r)
+	if r.Header.Get("Accept") == jsonMediaType {
+		b, err := json.MarshalIndent(headers, "", "  ")
+		if err != nil {
+			return internalServerError(err).AsJSON()
+		}
+		w.Header().Set("Content-Type", jsonMediaType)
+		w.Write(b)
+		return nil
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		for _, value := range headers[name] {
+			fmt.Fprintf(w, "%s: %s\n", name, value)
+		}
+	}
+	return nil
+}
+
+// RequestHandler writes a description of the request as JSON.
+func (s *Server) RequestHandler(w http.ResponseWriter, r *http.Request) *appError {
+	response, err := s.newRequestResponse(r)
+	if err != nil {
+		return badRequest(err).WithMessage(err.Error()).AsJSON()
+	}
+	b, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return internalServerError(err).AsJSON()
+	}
+	w.Header().Set("Content-Type", jsonMediaType)
+	w.Write(b)
+	return nil
+}
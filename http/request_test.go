@@ -0,0 +1,121 @@
+package http
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHeadersHandler(t *testing.T) {
+	s := testServer()
+	s.RedactHeaders = []string{"authorization", "Cookie"}
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/headers", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Add("Cookie", "a=1")
+	r.Header.Add("Cookie", "b=2")
+	r.Header.Set("X-Foo", "bar")
+
+	w := httptest.NewRecorder()
+	if err := s.HeadersHandler(w, r); err != nil {
+		t.Fatal(err)
+	}
+	want := "Authorization: [REDACTED]\n" +
+		"Cookie: [REDACTED]\n" +
+		"Cookie: [REDACTED]\n" +
+		"Host: example.com\n" +
+		"X-Foo: bar\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("request headers were modified: %q", got)
+	}
+
+	r.Header.Set("Accept", jsonMediaType)
+	w = httptest.NewRecorder()
+	if err := s.HeadersHandler(w, r); err != nil {
+		t.Fatal(err)
+	}
+	var headers http.Header
+	if err := json.Unmarshal(w.Body.Bytes(), &headers); err != nil {
+		t.Fatal(err)
+	}
+	if got := headers.Get("X-Foo"); got != "bar" {
+		t.Errorf("want X-Foo %q, got %q", "bar", got)
+	}
+	if got := headers.Values("Cookie"); !reflect.DeepEqual(got, []string{redacted, redacted}) {
+		t.Errorf("want redacted cookies, got %q", got)
+	}
+}
+
+func TestRequestHandler(t *testing.T) {
+	s := testServer()
+	s.IPHeaders = []string{"X-Real-IP", "X-Forwarded-For"}
+	var tests = []struct {
+		headers   map[string]string
+		ip        string
+		source    string
+		ipHeaders []IPHeader
+	}{
+		{nil, "192.0.2.1", "remote_addr", []IPHeader{{Name: "X-Real-Ip"}, {Name: "X-Forwarded-For"}}},
+		{map[string]string{"X-Forwarded-For": "198.51.100.1, 192.0.2.1"}, "198.51.100.1", "X-Forwarded-For",
+			[]IPHeader{{Name: "X-Real-Ip"}, {Name: "X-Forwarded-For", Value: "198.51.100.1, 192.0.2.1", Used: true}}},
+		{map[string]string{"X-Real-IP": "203.0.113.1", "X-Forwarded-For": "198.51.100.1"}, "203.0.113.1", "X-Real-IP",
+			[]IPHeader{{Name: "X-Real-Ip", Value: "203.0.113.1", Used: true}, {Name: "X-Forwarded-For", Value: "198.51.100.1"}}},
+	}
+	for i, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/request?foo=bar", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.TLS = &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, ServerName: "example.com", NegotiatedProtocol: "h2"}
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		if err := s.RequestHandler(w, r); err != nil {
+			t.Fatal(err)
+		}
+		var response RequestResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Method != http.MethodGet || response.URL != "https://example.com/request?foo=bar" || response.Protocol != "HTTP/1.1" || response.RemoteAddr != "192.0.2.1:1234" {
+			t.Errorf("#%d: unexpected response: %+v", i, response)
+		}
+		wantTLS := RequestTLS{Version: "TLS 1.3", CipherSuite: "TLS_AES_128_GCM_SHA256", ServerName: "example.com", ALPN: "h2"}
+		if response.TLS == nil || *response.TLS != wantTLS {
+			t.Errorf("#%d: want TLS %+v, got %+v", i, wantTLS, response.TLS)
+		}
+		if response.IP.String() != tt.ip || response.IPSource != tt.source {
+			t.Errorf("#%d: want IP %s from %s, got %s from %s", i, tt.ip, tt.source, response.IP, response.IPSource)
+		}
+		if !reflect.DeepEqual(response.IPHeaders, tt.ipHeaders) {
+			t.Errorf("#%d: want IP headers %+v, got %+v", i, tt.ipHeaders, response.IPHeaders)
+		}
+	}
+}
+
+func TestRequestHandlerRedactsIPHeaders(t *testing.T) {
+	s := testServer()
+	s.IPHeaders = []string{"X-Real-IP", "X-Forwarded-For"}
+	s.RedactHeaders = []string{"x-forwarded-for"}
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/request", nil)
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	w := httptest.NewRecorder()
+	if err := s.RequestHandler(w, r); err != nil {
+		t.Fatal(err)
+	}
+	var response RequestResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	want := []IPHeader{{Name: "X-Real-Ip"}, {Name: "X-Forwarded-For", Value: redacted, Used: true}}
+	if !reflect.DeepEqual(response.IPHeaders, want) {
+		t.Errorf("want IP headers %+v, got %+v", want, response.IPHeaders)
+	}
+	if got := response.Headers.Get("X-Forwarded-For"); got != redacted {
+		t.Errorf("want redacted header, got %q", got)
+	}
+}
@@ -0,0 +1,131 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Timeouts configures the timeouts of the underlying http.Server. See
+// http.Server for their meaning. A zero value means no timeout.
+type Timeouts struct {
+	Read       time.Duration
+	ReadHeader time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+func (s *Server) newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       s.Timeouts.Read,
+		ReadHeaderTimeout: s.Timeouts.ReadHeader,
+		WriteTimeout:      s.Timeouts.Write,
+		IdleTimeout:       s.Timeouts.Idle,
+		MaxHeaderBytes:    s.MaxHeaderBytes,
+	}
+}
+
+// shutdowner is a server stopped by Shutdown, such as *http.Server.
+type shutdowner interface {
+	Shutdown(context.Context) error
+}
+
+// addServer registers srv so that it is stopped by Shutdown. It returns false
+// if the server is already shutting down.
+func (s *Server) addServer(srv shutdowner) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining.Load() {
+		return false
+	}
+	s.servers = append(s.servers, srv)
+	return true
+}
+
+// plainHandler returns the handler of plain HTTP listeners.
+func (s *Server) plainHandler() http.Handler {
+	handler := s.Handler()
+	if s.TLSRedirectPort != "" {
+		handler = s.redirectHandler(s.TLSRedirectPort, handler)
+	}
+	if s.ACMEChallenge != nil {
+		handler = s.ACMEChallenge(handler)
+	}
+	return handler
+}
+
+// ListenAndServe listens on the network address addr and serves requests until
+// Shutdown is called, in which case http.ErrServerClosed is returned. The
+// network must be one of those returned by ParseListenAddr.
+func (s *Server) ListenAndServe(network, addr string) error {
+	return s.listenAndServe(network, addr, s.plainHandler(), nil)
+}
+
+// ListenAndServeTLS is like ListenAndServe, but serves HTTPS using config.
+func (s *Server) ListenAndServeTLS(network, addr string, config *tls.Config) error {
+	return s.listenAndServe(network, addr, s.altSvcHandler(s.Handler()), config)
+}
+
+func (s *Server) listenAndServe(network, addr string, handler http.Handler, config *tls.Config) error {
+	listeners, err := s.listen(network, addr)
+	if err != nil {
+		return err
+	}
+	// A systemd listening address may yield multiple listeners. Return as soon
+	// as any of them stops
+	errCh := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() { errCh <- s.serve(l, handler, config) }()
+	}
+	return <-errCh
+}
+
+func (s *Server) serve(l boundListener, handler http.Handler, config *tls.Config) error {
+	srv := s.newHTTPServer(l.info.Address, handler)
+	srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		ctx = context.WithValue(ctx, listenerKey{}, &l.info)
+		if c, ok := c.(*tls.Conn); ok {
+			if hc, ok := c.NetConn().(*helloConn); ok {
+				ctx = context.WithValue(ctx, clientHelloKey{}, hc)
+			}
+		}
+		return ctx
+	}
+	if !s.addServer(srv) {
+		l.Close()
+		return http.ErrServerClosed
+	}
+	if config != nil {
+		srv.TLSConfig = config
+		// Record the ClientHello of each connection for fingerprinting
+		return srv.ServeTLS(helloListener{l}, "", "")
+	}
+	return srv.Serve(l)
+}
+
+// Shutdown gracefully stops the server. The health check reports the server as
+// draining for the duration of DrainDelay, while requests are still accepted.
+// Then listeners are closed and Shutdown waits for in-flight requests to
+// complete, or ctx to be done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.draining.Store(true)
+	servers := s.servers
+	s.mu.Unlock()
+	if s.DrainDelay > 0 {
+		select {
+		case <-time.After(s.DrainDelay):
+		case <-ctx.Done():
+		}
+	}
+	var errs []error
+	for _, srv := range servers {
+		errs = append(errs, srv.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,107 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestShutdown(t *testing.T) {
+	s := testServer()
+	s.DrainDelay = 100 * time.Millisecond
+	addr := freeAddr(t)
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.ListenAndServe("tcp", addr) }()
+
+	// Wait for server to start
+	url := "http://" + addr + "/health"
+	for i := 0; ; i++ {
+		if _, _, err := httpGet(url, jsonMediaType, "curl/7.2.6.0"); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- s.Shutdown(context.Background()) }()
+
+	// Health check reports draining while requests are still accepted
+	for i := 0; ; i++ {
+		out, status, err := httpGet(url, jsonMediaType, "curl/7.2.6.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status == http.StatusServiceUnavailable {
+			if want := `{"status":"draining"}`; out != want {
+				t.Errorf("want %q, got %q", want, out)
+			}
+			break
+		} else if i == 50 {
+			t.Fatalf("want status %d, got %d", http.StatusServiceUnavailable, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := <-shutdownErr; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("want %s, got %v", http.ErrServerClosed, err)
+	}
+	if err := s.ListenAndServe("tcp", addr); !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("want %s after shutdown, got %v", http.ErrServerClosed, err)
+	}
+}
+
+func TestShutdownWaitsForRequests(t *testing.T) {
+	s := testServer()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	s.LookupAddr = func(ctx context.Context, ip net.IP) ([]string, error) {
+		close(started)
+		<-release
+		return []string{"localhost"}, nil
+	}
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Config = s.newHTTPServer("", s.Handler())
+	if !s.addServer(srv.Config) {
+		t.Fatal("expected server to be added")
+	}
+	srv.Start()
+	defer srv.Close()
+
+	reqErr := make(chan error, 1)
+	go func() {
+		_, status, err := httpGet(srv.URL+"/hostname", "", "curl/7.2.6.0")
+		if err == nil && status != http.StatusOK {
+			err = errors.New(http.StatusText(status))
+		}
+		reqErr <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want %s, got %v", context.DeadlineExceeded, err)
+	}
+	close(release)
+	if err := <-reqErr; err != nil {
+		t.Errorf("in-flight request failed: %s", err)
+	}
+}
@@ -0,0 +1,108 @@
+package http
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimum time between checking certificate files for changes
+const certCheckInterval = time.Second
+
+// CertReloader serves a certificate loaded from a pair of files, reloading it
+// when either file changes.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime [2]time.Time
+	checked time.Time
+}
+
+// NewCertReloader loads the certificate and key in certFile and keyFile.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, interval: certCheckInterval}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) modTimes() ([2]time.Time, error) {
+	var modTime [2]time.Time
+	for i, name := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return modTime, err
+		}
+		modTime[i] = fi.ModTime()
+	}
+	return modTime, nil
+}
+
+func (r *CertReloader) reload() error {
+	modTime, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// GetCertificate returns the current certificate. It is suitable for use as
+// tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Sub(r.checked) < r.interval {
+		return r.cert, nil
+	}
+	r.checked = now
+	if modTime, err := r.modTimes(); err == nil && modTime != r.modTime {
+		// Keep serving the old certificate if the new one cannot be loaded,
+		// e.g. because only one of the files has been replaced so far
+		if err := r.reload(); err != nil {
+			log.Printf("failed to reload certificate: %s", err)
+		} else {
+			log.Printf("reloaded certificate from %s", r.certFile)
+		}
+	}
+	return r.cert, nil
+}
+
+// redirectHandler redirects requests to HTTPS on port. Plain text requests are
+// passed to handler, so that CLI clients can still use plain HTTP.
+func (s *Server) redirectHandler(port string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || cliMatcher(r) || r.Header.Get("Accept") == textMediaType {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+		url := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, url, http.StatusPermanentRedirect)
+	})
+}
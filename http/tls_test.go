@@ -0,0 +1,195 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCert(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "first")
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.interval = 0
+	commonName := func() string {
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+	if got := commonName(); got != "first" {
+		t.Errorf("want %q, got %q", "first", got)
+	}
+
+	// An invalid key keeps the old certificate
+	if err := os.WriteFile(keyFile, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := commonName(); got != "first" {
+		t.Errorf("want %q, got %q", "first", got)
+	}
+
+	writeCert(t, certFile, keyFile, "second")
+	future := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := commonName(); got != "second" {
+		t.Errorf("want %q, got %q", "second", got)
+	}
+
+	if _, err := NewCertReloader(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Error("expected error for missing certificate")
+	}
+}
+
+func TestListenAndServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "localhost")
+	certs, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testServer()
+	addr := freeAddr(t)
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.ListenAndServeTLS("tcp", addr, &tls.Config{GetCertificate: certs.GetCertificate}) }()
+	defer s.Shutdown(t.Context())
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	var res *http.Response
+	for i := 0; ; i++ {
+		res, err = client.Get("https://" + addr + "/health")
+		if err == nil {
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-errCh:
+			t.Fatal(err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("want status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	s := testServer()
+	s.TLSRedirectPort = "8443"
+	srv := httptest.NewServer(s.plainHandler())
+	defer srv.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	var tests = []struct {
+		url       string
+		userAgent string
+		accept    string
+		status    int
+		location  string
+	}{
+		{"/", "curl/7.26.0", "", http.StatusOK, ""},
+		{"/ip", "", textMediaType, http.StatusOK, ""},
+		{"/health", "Mozilla/5.0", "", http.StatusOK, ""},
+		{"/", "Mozilla/5.0", "", http.StatusPermanentRedirect, "https://example.com:8443/"},
+		{"/json?ip=127.0.0.1", "Mozilla/5.0", "", http.StatusPermanentRedirect, "https://example.com:8443/json?ip=127.0.0.1"},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest(http.MethodGet, srv.URL+tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Host = "example.com"
+		r.Header.Set("User-Agent", tt.userAgent)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		res, err := client.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.status {
+			t.Errorf("%s (%s): want status %d, got %d", tt.url, tt.userAgent, tt.status, res.StatusCode)
+		}
+		if got := res.Header.Get("Location"); got != tt.location {
+			t.Errorf("%s (%s): want location %q, got %q", tt.url, tt.userAgent, tt.location, got)
+		}
+	}
+
+	var ipv6Tests = []struct {
+		port     string
+		host     string
+		location string
+	}{
+		{"443", "[2001:db8::1]", "https://[2001:db8::1]/"},
+		{"443", "[2001:db8::1]:80", "https://[2001:db8::1]/"},
+		{"8443", "[2001:db8::1]", "https://[2001:db8::1]:8443/"},
+		{"8443", "[2001:db8::1]:80", "https://[2001:db8::1]:8443/"},
+	}
+	for _, tt := range ipv6Tests {
+		s.TLSRedirectPort = tt.port
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		s.plainHandler().ServeHTTP(w, r)
+		if got := w.Header().Get("Location"); got != tt.location {
+			t.Errorf("%s on port %s: want location %q, got %q", tt.host, tt.port, tt.location, got)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Package listener implements the bookkeeping of listeners shared by the
+// servers of protocols other than HTTP.
+package listener
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Default maximum number of connections handled concurrently by Serve
+	defaultMaxConns = 512
+	// Maximum delay before retrying Accept after a temporary error
+	maxAcceptDelay = time.Second
+)
+
+// Tracker tracks the listeners and packet connections of a server, so that
+// they can be closed by Close. The zero value is ready to use.
+type Tracker struct {
+	// MaxConns is the maximum number of connections handled concurrently by
+	// Serve, across all listeners. Connections accepted beyond it are closed
+	// immediately. Defaults to 512.
+	MaxConns int
+
+	mu      sync.Mutex
+	closers []io.Closer
+	closed  bool
+	conns   chan struct{}
+}
+
+// Track registers c to be closed by Close. If the tracker is already closed, c
+// is closed immediately and Track returns false.
+func (t *Tracker) Track(c io.Closer) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		c.Close()
+		return false
+	}
+	t.closers = append(t.closers, c)
+	return true
+}
+
+// Closed returns true if Close has been called.
+func (t *Tracker) Closed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+// Close closes all tracked listeners and connections, and any tracked later.
+func (t *Tracker) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	var errs []error
+	for _, c := range t.closers {
+		if err := c.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	t.closers = nil
+	return errors.Join(errs...)
+}
+
+// Serve tracks l and calls handle in a new goroutine for each connection
+// accepted by l, until Close is called, in which case Serve returns nil.
+// Temporary Accept errors, such as running out of file descriptors, are retried
+// with an increasing delay, like net/http.Server.Serve does.
+func (t *Tracker) Serve(l net.Listener, handle func(net.Conn)) error {
+	if !t.Track(l) {
+		return nil
+	}
+	conns := t.connSlots()
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if t.Closed() {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && (netErr.Timeout() || netErr.Temporary()) {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else {
+					delay *= 2
+				}
+				delay = min(delay, maxAcceptDelay)
+				log.Printf("listener: accept error: %s; retrying in %s", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		select {
+		case conns <- struct{}{}:
+		default:
+			// Too many connections
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer func() { <-conns }()
+			handle(conn)
+		}()
+	}
+}
+
+// connSlots returns the semaphore limiting the number of concurrent
+// connections.
+func (t *Tracker) connSlots() chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conns == nil {
+		n := t.MaxConns
+		if n <= 0 {
+			n = defaultMaxConns
+		}
+		t.conns = make(chan struct{}, n)
+	}
+	return t.conns
+}
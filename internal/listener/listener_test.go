@@ -0,0 +1,161 @@
+package listener
+
+import (
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyListener fails the first n calls to Accept with err.
+type flakyListener struct {
+	net.Listener
+	n   int
+	err error
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.n > 0 {
+		l.n--
+		return nil, l.err
+	}
+	return l.Listener.Accept()
+}
+
+func TestTracker(t *testing.T) {
+	var tracker Tracker
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handled := make(chan net.Addr, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tracker.Serve(l, func(conn net.Conn) {
+			defer conn.Close()
+			handled <- conn.RemoteAddr()
+		})
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case addr := <-handled:
+		if addr.String() != conn.LocalAddr().String() {
+			t.Errorf("want connection from %s, got %s", conn.LocalAddr(), addr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not handled")
+	}
+
+	if err := tracker.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Errorf("want nil error after close, got %s", err)
+	}
+	if !tracker.Closed() {
+		t.Error("want tracker to be closed")
+	}
+
+	// Listeners tracked after Close are closed immediately
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Serve(l, nil); err != nil {
+		t.Errorf("want nil error when serving after close, got %s", err)
+	}
+	if _, err := l.Accept(); err == nil {
+		t.Error("want listener to be closed")
+	}
+}
+
+func TestTrackerTemporaryError(t *testing.T) {
+	var tracker Tracker
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	emfile := &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+	handled := make(chan struct{}, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tracker.Serve(&flakyListener{Listener: l, n: 3, err: emfile}, func(conn net.Conn) {
+			conn.Close()
+			handled <- struct{}{}
+		})
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case <-handled:
+	case err := <-errCh:
+		t.Fatalf("want retry after temporary error, got %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not handled")
+	}
+	tracker.Close()
+	if err := <-errCh; err != nil {
+		t.Errorf("want nil error after close, got %s", err)
+	}
+}
+
+func TestTrackerMaxConns(t *testing.T) {
+	tracker := Tracker{MaxConns: 1}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	handled := make(chan struct{}, 2)
+	go tracker.Serve(l, func(conn net.Conn) {
+		defer conn.Close()
+		handled <- struct{}{}
+		<-release
+	})
+	defer tracker.Close()
+
+	first, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	<-handled
+
+	// The second connection is closed while the first is being handled
+	second, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("want EOF for connection over the limit, got %v", err)
+	}
+	close(release)
+
+	// The slot is freed shortly after the first handler returns
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		select {
+		case <-handled:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		if i == 100 {
+			t.Fatal("connection not handled after the limit was freed")
+		}
+	}
+}
@@ -1,16 +1,20 @@
 package geo
 
 import (
+	"context"
+	"fmt"
 	"math"
 	"net"
+	"strings"
 
 	geoip2 "github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
 )
 
 type Reader interface {
-	Country(net.IP) (Country, error)
-	City(net.IP) (City, error)
-	ASN(net.IP) (ASN, error)
+	Country(context.Context, net.IP) (Country, error)
+	City(context.Context, net.IP) (City, error)
+	ASN(context.Context, net.IP) (ASN, error)
 	IsEmpty() bool
 }
 
@@ -34,16 +38,21 @@ type City struct {
 type ASN struct {
 	AutonomousSystemNumber       uint
 	AutonomousSystemOrganization string
+	// The network in the database containing the IP
+	Network *net.IPNet
 }
 
 type geoip struct {
 	country *geoip2.Reader
 	city    *geoip2.Reader
-	asn     *geoip2.Reader
+	// The ASN database is read directly, as geoip2 does not expose the network
+	// of a record
+	asn *maxminddb.Reader
 }
 
 func Open(countryDB, cityDB string, asnDB string) (Reader, error) {
-	var country, city, asn *geoip2.Reader
+	var country, city *geoip2.Reader
+	var asn *maxminddb.Reader
 	if countryDB != "" {
 		r, err := geoip2.Open(countryDB)
 		if err != nil {
@@ -59,20 +68,28 @@ func Open(countryDB, cityDB string, asnDB string) (Reader, error) {
 		city = r
 	}
 	if asnDB != "" {
-		r, err := geoip2.Open(asnDB)
+		r, err := maxminddb.Open(asnDB)
 		if err != nil {
 			return nil, err
 		}
+		// geoip2 checks the type of the other databases
+		if !strings.Contains(r.Metadata.DatabaseType, "ASN") {
+			r.Close()
+			return nil, fmt.Errorf("%s: database type %s is not ASN", asnDB, r.Metadata.DatabaseType)
+		}
 		asn = r
 	}
 	return &geoip{country: country, city: city, asn: asn}, nil
 }
 
-func (g *geoip) Country(ip net.IP) (Country, error) {
+func (g *geoip) Country(ctx context.Context, ip net.IP) (Country, error) {
 	country := Country{}
 	if g.country == nil {
 		return country, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return country, err
+	}
 	record, err := g.country.Country(ip)
 	if err != nil {
 		return country, err
@@ -93,11 +110,14 @@ func (g *geoip) Country(ip net.IP) (Country, error) {
 	return country, nil
 }
 
-func (g *geoip) City(ip net.IP) (City, error) {
+func (g *geoip) City(ctx context.Context, ip net.IP) (City, error) {
 	city := City{}
 	if g.city == nil {
 		return city, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return city, err
+	}
 	record, err := g.city.City(ip)
 	if err != nil {
 		return city, err
@@ -133,15 +153,22 @@ func (g *geoip) City(ip net.IP) (City, error) {
 	return city, nil
 }
 
-func (g *geoip) ASN(ip net.IP) (ASN, error) {
+func (g *geoip) ASN(ctx context.Context, ip net.IP) (ASN, error) {
 	asn := ASN{}
 	if g.asn == nil {
 		return asn, nil
 	}
-	record, err := g.asn.ASN(ip)
+	if err := ctx.Err(); err != nil {
+		return asn, err
+	}
+	var record geoip2.ASN
+	network, ok, err := g.asn.LookupNetwork(ip, &record)
 	if err != nil {
 		return asn, err
 	}
+	if ok {
+		asn.Network = network
+	}
 	if record.AutonomousSystemNumber > 0 {
 		asn.AutonomousSystemNumber = record.AutonomousSystemNumber
 	}
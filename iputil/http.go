@@ -0,0 +1,82 @@
+package iputil
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Maximum number of bytes read from the response of an HTTP check
+const httpBodyLimit = 1024
+
+// HTTPResult is the result of an HTTP check.
+type HTTPResult struct {
+	StatusCode   int
+	ResponseTime time.Duration
+	Server       string
+	Location     string
+	TLSRequired  bool
+}
+
+// LookupHTTP sends a GET request to the web server listening on port. Plain
+// HTTP is tried first, falling back to HTTPS if the server requires TLS.
+// Redirects are not followed.
+func LookupHTTP(ctx context.Context, ip net.IP, port uint64) (HTTPResult, error) {
+	ctx, cancel := portContext(ctx)
+	defer cancel()
+	host := net.JoinHostPort(ip.String(), fmt.Sprint(port))
+	result, body, err := httpGet(ctx, "http://"+host+"/")
+	if err == nil && !(result.StatusCode == http.StatusBadRequest && strings.Contains(body, "HTTPS")) {
+		return result, nil
+	}
+	// The server either failed to speak plain HTTP, or answered with a 400
+	// mentioning HTTPS, as web servers commonly do when receiving plain HTTP on
+	// a TLS port
+	tlsResult, _, tlsErr := httpGet(ctx, "https://"+host+"/")
+	if tlsErr != nil {
+		if err == nil {
+			return result, nil
+		}
+		return HTTPResult{}, err
+	}
+	tlsResult.TLSRequired = true
+	return tlsResult, nil
+}
+
+func httpGet(ctx context.Context, url string) (HTTPResult, string, error) {
+	client := http.Client{
+		Transport: &http.Transport{
+			Proxy:                  nil,
+			DisableKeepAlives:      true,
+			MaxResponseHeaderBytes: 16 << 10,
+			TLSClientConfig:        &tls.Config{InsecureSkipVerify: true},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return HTTPResult{}, "", err
+	}
+	req.Header.Set("User-Agent", "echoip")
+	start := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		return HTTPResult{}, "", err
+	}
+	defer res.Body.Close()
+	result := HTTPResult{
+		StatusCode:   res.StatusCode,
+		ResponseTime: time.Since(start),
+		Server:       res.Header.Get("Server"),
+		Location:     res.Header.Get("Location"),
+	}
+	body, _ := io.ReadAll(io.LimitReader(res.Body, httpBodyLimit))
+	return result, string(body), nil
+}
@@ -0,0 +1,61 @@
+package iputil
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLookupHTTP(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "test")
+		http.Redirect(w, r, "https://example.com/", http.StatusMovedPermanently)
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	tls := httptest.NewTLSServer(handler)
+	defer tls.Close()
+
+	var tests = []struct {
+		port        uint64
+		tlsRequired bool
+	}{
+		{listenerPort(t, plain.Listener.Addr()), false},
+		{listenerPort(t, tls.Listener.Addr()), true},
+	}
+	for _, tt := range tests {
+		result, err := LookupHTTP(context.Background(), net.ParseIP("127.0.0.1"), tt.port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := result.StatusCode, http.StatusMovedPermanently; got != want {
+			t.Errorf("port %d: got status %d, want %d", tt.port, got, want)
+		}
+		if got, want := result.Server, "test"; got != want {
+			t.Errorf("port %d: got server %q, want %q", tt.port, got, want)
+		}
+		if got, want := result.Location, "https://example.com/"; got != want {
+			t.Errorf("port %d: got location %q, want %q", tt.port, got, want)
+		}
+		if result.TLSRequired != tt.tlsRequired {
+			t.Errorf("port %d: got tlsRequired=%t, want %t", tt.port, result.TLSRequired, tt.tlsRequired)
+		}
+		if result.ResponseTime <= 0 {
+			t.Errorf("port %d: expected response time to be set", tt.port)
+		}
+	}
+}
+
+func TestLookupHTTPUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listenerPort(t, l.Addr())
+	l.Close()
+	if _, err := LookupHTTP(context.Background(), net.ParseIP("127.0.0.1"), port); err == nil {
+		t.Error("expected error")
+	}
+}
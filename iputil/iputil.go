@@ -1,6 +1,8 @@
 package iputil
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"math/big"
 	"net"
@@ -8,18 +10,57 @@ import (
 	"time"
 )
 
-func LookupAddr(ip net.IP) (string, error) {
-	names, err := net.LookupAddr(ip.String())
-	if err != nil || len(names) == 0 {
-		return "", err
+func LookupAddr(ctx context.Context, ip net.IP) ([]string, error) {
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip.String())
+	if err != nil {
+		return nil, err
+	}
+	return unrooted(names), nil
+}
+
+func LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+// Resolves reports whether host resolves to ip using lookupIP.
+func Resolves(ctx context.Context, lookupIP func(context.Context, string) ([]net.IP, error), host string, ip net.IP) bool {
+	ips, err := lookupIP(ctx, host)
+	if err != nil {
+		return false
+	}
+	for _, other := range ips {
+		if other.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Always return unrooted names
+func unrooted(names []string) []string {
+	for i, name := range names {
+		names[i] = strings.TrimRight(name, ".")
+	}
+	return names
+}
+
+// DefaultPortTimeout is the timeout of port checks and probes, if the context
+// passed to them has no deadline.
+const DefaultPortTimeout = 2 * time.Second
+
+func portContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
 	}
-	// Always return unrooted name
-	return strings.TrimRight(names[0], "."), nil
+	return context.WithTimeout(ctx, DefaultPortTimeout)
 }
 
-func LookupPort(ip net.IP, port uint64) error {
+func LookupPort(ctx context.Context, ip net.IP, port uint64) error {
 	address := fmt.Sprintf("[%s]:%d", ip, port)
-	conn, err := net.DialTimeout("tcp", address, 2*time.Second)
+	ctx, cancel := portContext(ctx)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
 	if err != nil {
 		return err
 	}
@@ -27,6 +68,50 @@ func LookupPort(ip net.IP, port uint64) error {
 	return nil
 }
 
+// ProbeTLS performs a TLS handshake with the service listening on port and
+// returns the resulting connection state. The certificate is not verified.
+func ProbeTLS(ctx context.Context, ip net.IP, port uint64) (tls.ConnectionState, error) {
+	address := fmt.Sprintf("[%s]:%d", ip, port)
+	ctx, cancel := portContext(ctx)
+	defer cancel()
+	d := tls.Dialer{
+		Config: &tls.Config{
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h2", "http/1.1"},
+		},
+	}
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+	return conn.(*tls.Conn).ConnectionState(), nil
+}
+
+// ProbeBanner reads the first bytes sent by the service listening on port, such
+// as the greeting of an SSH, SMTP or FTP server. At most limit bytes are read.
+func ProbeBanner(ctx context.Context, ip net.IP, port uint64, limit int) ([]byte, error) {
+	address := fmt.Sprintf("[%s]:%d", ip, port)
+	ctx, cancel := portContext(ctx)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, limit)
+	n, err := conn.Read(buf)
+	if n > 0 {
+		return buf[:n], nil
+	}
+	return nil, err
+}
+
 func ToDecimal(ip net.IP) *big.Int {
 	i := big.NewInt(0)
 	if to4 := ip.To4(); to4 != nil {
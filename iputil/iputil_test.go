@@ -1,8 +1,13 @@
 package iputil
 
 import (
+	"context"
+	"crypto/tls"
 	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"testing"
 )
 
@@ -25,3 +30,71 @@ func TestToDecimal(t *testing.T) {
 		}
 	}
 }
+
+func listenerPort(t *testing.T, addr net.Addr) uint64 {
+	_, p, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+func TestProbeTLS(t *testing.T) {
+	s := httptest.NewUnstartedServer(http.NotFoundHandler())
+	s.EnableHTTP2 = true
+	s.StartTLS()
+	defer s.Close()
+	port := listenerPort(t, s.Listener.Addr())
+	state, err := ProbeTLS(context.Background(), net.ParseIP("127.0.0.1"), port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := state.Version, uint16(tls.VersionTLS13); got != want {
+		t.Errorf("got version %s, want %s", tls.VersionName(got), tls.VersionName(want))
+	}
+	if got, want := state.NegotiatedProtocol, "h2"; got != want {
+		t.Errorf("got protocol %q, want %q", got, want)
+	}
+	if len(state.PeerCertificates) == 0 {
+		t.Error("expected peer certificates")
+	}
+}
+
+func TestProbeBanner(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			conn.Close()
+		}
+	}()
+	port := listenerPort(t, l.Addr())
+	var tests = []struct {
+		limit int
+		out   string
+	}{
+		{256, "SSH-2.0-OpenSSH_9.6\r\n"},
+		{7, "SSH-2.0"},
+	}
+	for _, tt := range tests {
+		b, err := ProbeBanner(context.Background(), net.ParseIP("127.0.0.1"), port, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != tt.out {
+			t.Errorf("got %q, want %q", got, tt.out)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package iputil
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Maximum size of UDP responses advertised using EDNS. See
+// https://www.dnsflagday.net/2020/
+const maxUDPSize = 1232
+
+// Resolver performs DNS lookups against a fixed set of DNS servers, instead of
+// the ones configured by the host. Queries are sent directly to the servers, so
+// the hosts file and other name services of the host are never consulted.
+type Resolver struct {
+	// Timeout is the maximum duration of a single query attempt.
+	Timeout time.Duration
+	// Retries is the number of times a failed query is retried.
+	Retries int
+	// TLSConfig is the TLS configuration used when network is "tls". If nil,
+	// the default configuration is used.
+	TLSConfig *tls.Config
+
+	servers []string
+	network string
+	next    atomic.Uint64
+}
+
+// NewResolver creates a resolver querying the given servers in round-robin
+// order. Network is one of "udp", "tcp" or "tls" (DNS-over-TLS). Servers
+// without a port use 53, or 853 for DNS-over-TLS.
+func NewResolver(servers []string, network string) (*Resolver, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("no dns servers given")
+	}
+	defaultPort := "53"
+	switch network {
+	case "udp", "tcp":
+	case "tls":
+		defaultPort = "853"
+	default:
+		return nil, fmt.Errorf("invalid dns network: %s", network)
+	}
+	r := &Resolver{Timeout: 2 * time.Second, Retries: 1, network: network}
+	for _, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), defaultPort)
+		}
+		r.servers = append(r.servers, server)
+	}
+	return r, nil
+}
+
+func (r *Resolver) dial(ctx context.Context, network, server string) (net.Conn, error) {
+	var d net.Dialer
+	switch r.network {
+	case "tls":
+		td := tls.Dialer{NetDialer: &d, Config: r.TLSConfig}
+		return td.DialContext(ctx, "tcp", server)
+	case "tcp":
+		network = "tcp"
+	}
+	return d.DialContext(ctx, network, server)
+}
+
+// retry calls fn until it succeeds, fails with a negative answer, or the
+// number of retries is exhausted. Each attempt queries the next server.
+func (r *Resolver) retry(ctx context.Context, fn func(ctx context.Context, server string) error) error {
+	var err error
+	start := r.next.Add(1) - 1
+	for attempt := 0; attempt <= r.Retries; attempt++ {
+		server := r.servers[(start+uint64(attempt))%uint64(len(r.servers))]
+		err = func() error {
+			ctx := ctx
+			if r.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+				defer cancel()
+			}
+			return fn(ctx, server)
+		}()
+		var dnsErr *net.DNSError
+		if err == nil || ctx.Err() != nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+			break
+		}
+	}
+	return err
+}
+
+// exchange sends a query for q to server and returns the answers. Truncated
+// UDP responses are retried over TCP.
+func (r *Resolver) exchange(ctx context.Context, server string, q dnsmessage.Question) ([]dnsmessage.Resource, error) {
+	var id [2]byte
+	rand.Read(id[:])
+	query, err := newQuery(binary.BigEndian.Uint16(id[:]), q)
+	if err != nil {
+		return nil, err
+	}
+	network := "udp"
+	if r.network != "udp" {
+		network = "tcp"
+	}
+	msg, err := r.roundTrip(ctx, network, server, query, q)
+	if err == nil && msg.Truncated && network == "udp" {
+		msg, err = r.roundTrip(ctx, "tcp", server, query, q)
+	}
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: q.Name.String(), Server: server, IsTimeout: errors.Is(err, context.DeadlineExceeded) || isTimeout(err)}
+	}
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess:
+		return msg.Answers, nil
+	case dnsmessage.RCodeNameError:
+		return nil, notFound(q.Name.String(), server)
+	default:
+		return nil, &net.DNSError{Err: "server responded with " + msg.RCode.String(), Name: q.Name.String(), Server: server, IsTemporary: msg.RCode == dnsmessage.RCodeServerFailure}
+	}
+}
+
+// roundTrip sends query to server over network, and returns the response to
+// the query for q.
+func (r *Resolver) roundTrip(ctx context.Context, network, server string, query []byte, q dnsmessage.Question) (*dnsmessage.Message, error) {
+	conn, err := r.dial(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Unblock reads and writes when ctx is canceled
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	id := binary.BigEndian.Uint16(query)
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, maxUDPSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			// Ignore responses to other queries, e.g. spoofed or late ones
+			if msg, err := parseResponse(buf[:n], id, q); err == nil {
+				return msg, nil
+			}
+		}
+	}
+	if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(query)))); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	return parseResponse(buf, id, q)
+}
+
+// newQuery returns a recursive query for q, advertising the maximum UDP size
+// using EDNS.
+func newQuery(id uint16, q dnsmessage.Question) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(maxUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	if err := b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// parseResponse parses b, and returns an error unless it is a response to the
+// query with id for q.
+func parseResponse(b []byte, id uint16, q dnsmessage.Question) (*dnsmessage.Message, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(b); err != nil {
+		return nil, err
+	}
+	if !msg.Response || msg.ID != id || len(msg.Questions) != 1 || msg.Questions[0].Type != q.Type ||
+		!strings.EqualFold(msg.Questions[0].Name.String(), q.Name.String()) {
+		return nil, errors.New("response does not match query")
+	}
+	return &msg, nil
+}
+
+func notFound(name, server string) error {
+	return &net.DNSError{Err: "no such host", Name: name, Server: server, IsNotFound: true}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// reverseName returns the name used for reverse lookups of ip.
+func reverseName(ip net.IP) (dnsmessage.Name, error) {
+	var sb strings.Builder
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			fmt.Fprintf(&sb, "%d.", ip4[i])
+		}
+		sb.WriteString("in-addr.arpa.")
+	} else if ip6 := ip.To16(); ip6 != nil {
+		for i := len(ip6) - 1; i >= 0; i-- {
+			fmt.Fprintf(&sb, "%x.%x.", ip6[i]&0xf, ip6[i]>>4)
+		}
+		sb.WriteString("ip6.arpa.")
+	} else {
+		return dnsmessage.Name{}, fmt.Errorf("invalid ip: %s", ip)
+	}
+	return dnsmessage.NewName(sb.String())
+}
+
+// isDomainName reports whether name is a valid host name, so that names
+// returned by PTR records can be used as host names.
+func isDomainName(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// LookupAddr performs a reverse lookup of ip and returns all names.
+func (r *Resolver) LookupAddr(ctx context.Context, ip net.IP) ([]string, error) {
+	name, err := reverseName(ip)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	err = r.retry(ctx, func(ctx context.Context, server string) error {
+		answers, err := r.exchange(ctx, server, dnsmessage.Question{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET})
+		if err != nil {
+			return err
+		}
+		names = nil
+		for _, rr := range answers {
+			if ptr, ok := rr.Body.(*dnsmessage.PTRResource); ok && isDomainName(ptr.PTR.String()) {
+				names = append(names, ptr.PTR.String())
+			}
+		}
+		if len(names) == 0 {
+			return notFound(name.String(), server)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return unrooted(names), nil
+}
+
+// LookupIP returns the IPv4 and IPv6 addresses of host.
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if !strings.HasSuffix(host, ".") {
+		host += "."
+	}
+	name, err := dnsmessage.NewName(host)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	err = r.retry(ctx, func(ctx context.Context, server string) error {
+		ips = nil
+		for _, typ := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+			// The name does not exist for any type if it does not exist for A
+			answers, err := r.exchange(ctx, server, dnsmessage.Question{Name: name, Type: typ, Class: dnsmessage.ClassINET})
+			if err != nil {
+				return err
+			}
+			for _, rr := range answers {
+				switch body := rr.Body.(type) {
+				case *dnsmessage.AResource:
+					ips = append(ips, net.IP(body.A[:]))
+				case *dnsmessage.AAAAResource:
+					ips = append(ips, net.IP(body.AAAA[:]))
+				}
+			}
+		}
+		if len(ips) == 0 {
+			return notFound(host, server)
+		}
+		return nil
+	})
+	return ips, err
+}
@@ -0,0 +1,350 @@
+package iputil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsServer is a minimal stand-in DNS server answering queries from a fixed
+// set of records.
+type dnsServer struct {
+	records map[string][]dnsmessage.Resource
+	queries atomic.Int64
+}
+
+func (s *dnsServer) answer(query []byte) []byte {
+	s.queries.Add(1)
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+	rcode := dnsmessage.RCodeSuccess
+	var answers []dnsmessage.Resource
+	for _, rr := range s.records[strings.ToLower(q.Name.String())] {
+		if rr.Header.Type == q.Type {
+			answers = append(answers, rr)
+		}
+	}
+	if _, ok := s.records[strings.ToLower(q.Name.String())]; !ok {
+		rcode = dnsmessage.RCodeNameError
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 h.ID,
+			Response:           true,
+			Authoritative:      true,
+			RecursionDesired:   h.RecursionDesired,
+			RecursionAvailable: true,
+			RCode:              rcode,
+		},
+		Questions: []dnsmessage.Question{q},
+		Answers:   answers,
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (s *dnsServer) serveUDP(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if b := s.answer(buf[:n]); b != nil {
+				conn.WriteTo(b, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func (s *dnsServer) serveStream(t *testing.T, config *tls.Config) string {
+	var (
+		l   net.Listener
+		err error
+	)
+	if config != nil {
+		l, err = tls.Listen("tcp", "127.0.0.1:0", config)
+	} else {
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					var length uint16
+					if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+						return
+					}
+					query := make([]byte, length)
+					if _, err := io.ReadFull(conn, query); err != nil {
+						return
+					}
+					b := s.answer(query)
+					binary.Write(conn, binary.BigEndian, uint16(len(b)))
+					conn.Write(b)
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func ptrRecord(name, target string) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: 60},
+		Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(target)},
+	}
+}
+
+func aRecord(name string, ip [4]byte) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+		Body:   &dnsmessage.AResource{A: ip},
+	}
+}
+
+func testDNSServer() *dnsServer {
+	return &dnsServer{records: map[string][]dnsmessage.Resource{
+		"1.2.0.192.in-addr.arpa.": {
+			ptrRecord("1.2.0.192.in-addr.arpa.", "host.example."),
+			ptrRecord("1.2.0.192.in-addr.arpa.", "alias.example."),
+			// Names that are not valid host names are ignored
+			ptrRecord("1.2.0.192.in-addr.arpa.", "<script>.example."),
+		},
+		"host.example.": {aRecord("host.example.", [4]byte{192, 0, 2, 1})},
+	}}
+}
+
+func TestResolverLookupAddr(t *testing.T) {
+	s := testDNSServer()
+	tlsServer := httptest.NewUnstartedServer(nil)
+	tlsServer.StartTLS()
+	tlsServer.Close()
+	certPool := x509.NewCertPool()
+	certPool.AddCert(tlsServer.Certificate())
+
+	var tests = []struct {
+		network   string
+		server    string
+		tlsConfig *tls.Config
+	}{
+		{"udp", s.serveUDP(t), nil},
+		{"tcp", s.serveStream(t, nil), nil},
+		{"tls", s.serveStream(t, tlsServer.TLS), &tls.Config{RootCAs: certPool}},
+	}
+	for _, tt := range tests {
+		r, err := NewResolver([]string{tt.server}, tt.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.TLSConfig = tt.tlsConfig
+		got, err := r.LookupAddr(context.Background(), net.ParseIP("192.0.2.1"))
+		if err != nil {
+			t.Fatalf("%s: %s", tt.network, err)
+		}
+		if want := "host.example,alias.example"; strings.Join(got, ",") != want {
+			t.Errorf("%s: got %q, want %q", tt.network, got, want)
+		}
+	}
+}
+
+func TestResolverNotFound(t *testing.T) {
+	s := testDNSServer()
+	r, err := NewResolver([]string{s.serveUDP(t)}, "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Retries = 3
+	if _, err := r.LookupAddr(context.Background(), net.ParseIP("192.0.2.2")); err == nil {
+		t.Fatal("expected error")
+	}
+	// Negative answers are not retried
+	if got, want := s.queries.Load(), int64(1); got != want {
+		t.Errorf("got %d queries, want %d", got, want)
+	}
+}
+
+func TestResolverRetry(t *testing.T) {
+	// A server that never answers
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := testDNSServer()
+	r, err := NewResolver([]string{conn.LocalAddr().String(), s.serveUDP(t)}, "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Timeout = 100 * time.Millisecond
+	r.Retries = 1
+	got, err := r.LookupAddr(context.Background(), net.ParseIP("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) == 0 || got[0] != "host.example" {
+		t.Errorf("got %q, want %q", got, "host.example")
+	}
+}
+
+func TestResolverLookupIP(t *testing.T) {
+	s := testDNSServer()
+	r, err := NewResolver([]string{s.serveUDP(t)}, "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := net.ParseIP("192.0.2.1")
+	if !Resolves(context.Background(), r.LookupIP, "host.example", ip) {
+		t.Errorf("expected host.example to resolve to %s", ip)
+	}
+	if Resolves(context.Background(), r.LookupIP, "alias.example", ip) {
+		t.Errorf("expected alias.example to not resolve to %s", ip)
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	var tests = []struct {
+		servers []string
+		network string
+		out     []string
+		err     bool
+	}{
+		{[]string{"192.0.2.1"}, "udp", []string{"192.0.2.1:53"}, false},
+		{[]string{"192.0.2.1:5353", "2001:db8::1"}, "tcp", []string{"192.0.2.1:5353", "[2001:db8::1]:53"}, false},
+		{[]string{"[2001:db8::1]"}, "tls", []string{"[2001:db8::1]:853"}, false},
+		{[]string{"192.0.2.1"}, "foo", nil, true},
+		{nil, "udp", nil, true},
+	}
+	for _, tt := range tests {
+		r, err := NewResolver(tt.servers, tt.network)
+		if tt.err {
+			if err == nil {
+				t.Errorf("expected error for %v (%s)", tt.servers, tt.network)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(r.servers, ",") != strings.Join(tt.out, ",") {
+			t.Errorf("got %v, want %v", r.servers, tt.out)
+		}
+	}
+}
+
+func TestResolverIgnoresHost(t *testing.T) {
+	// A server that never answers
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r, err := NewResolver([]string{conn.LocalAddr().String()}, "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Timeout = 50 * time.Millisecond
+	r.Retries = 0
+	// Answered by the hosts file when using the resolver of the host
+	if names, err := r.LookupAddr(context.Background(), net.ParseIP("127.0.0.1")); err == nil {
+		t.Errorf("got %q, want error", names)
+	}
+	if ips, err := r.LookupIP(context.Background(), "localhost"); err == nil {
+		t.Errorf("got %q, want error", ips)
+	}
+}
+
+func TestResolverTruncated(t *testing.T) {
+	s := testDNSServer()
+	tcpAddr := s.serveStream(t, nil)
+	conn, err := net.ListenPacket("udp", tcpAddr)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	// Answer UDP queries with an empty truncated response
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			msg.Response, msg.Truncated = true, true
+			msg.Additionals = nil
+			if b, err := msg.Pack(); err == nil {
+				conn.WriteTo(b, addr)
+			}
+		}
+	}()
+	r, err := NewResolver([]string{tcpAddr}, "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.LookupAddr(context.Background(), net.ParseIP("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "host.example,alias.example"; strings.Join(got, ",") != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{"192.0.2.1", "1.2.0.192.in-addr.arpa."},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+	}
+	for _, tt := range tests {
+		name, err := reverseName(net.ParseIP(tt.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := name.String(); got != tt.out {
+			t.Errorf("reverseName(%s) = %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package iputil
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// States of a UDP port, as reported by LookupUDPPort.
+const (
+	UDPOpen         = "open"
+	UDPClosed       = "closed"
+	UDPOpenFiltered = "open|filtered"
+)
+
+// LookupUDPPort sends payload to the given UDP port and waits for a reply. The
+// port is open if anything is received, and closed if an ICMP port unreachable
+// message is received instead. The absence of both means that the port is
+// either open, but the service ignored the payload, or filtered.
+func LookupUDPPort(ctx context.Context, ip net.IP, port uint64, payload []byte) (string, error) {
+	address := fmt.Sprintf("[%s]:%d", ip, port)
+	ctx, cancel := portContext(ctx)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 512)
+	if _, err = conn.Write(payload); err == nil {
+		_, err = conn.Read(buf)
+	}
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return UDPOpen, nil
+	case errors.Is(err, syscall.ECONNREFUSED):
+		// A connected UDP socket surfaces ICMP port unreachable as a read error
+		return UDPClosed, nil
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return UDPOpenFiltered, nil
+	}
+	return "", err
+}
+
+// DNSProbe returns a DNS query suitable for checking whether a DNS server is
+// listening on a UDP port.
+func DNSProbe() []byte {
+	var id [2]byte
+	rand.Read(id[:])
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: dnsmessage.MustNewName("."), Type: dnsmessage.TypeNS, Class: dnsmessage.ClassINET},
+		},
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// WireGuardProbe returns a WireGuard handshake initiation message. Since the
+// message is not created with the peer's public key, a WireGuard server
+// silently drops it, but a closed port can still be detected.
+func WireGuardProbe() []byte {
+	// Message type (1), three reserved bytes, followed by sender index (4),
+	// ephemeral key (32), static key (48), timestamp (28), mac1 (16) and mac2
+	// (16)
+	b := make([]byte, 148)
+	b[0] = 1
+	rand.Read(b[4:116])
+	return b
+}
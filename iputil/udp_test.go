@@ -0,0 +1,79 @@
+package iputil
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func listenUDP(t *testing.T, reply bool) (net.PacketConn, uint64) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if reply {
+				conn.WriteTo(buf[:n], addr)
+			}
+		}
+	}()
+	return conn, listenerPort(t, conn.LocalAddr())
+}
+
+func TestLookupUDPPort(t *testing.T) {
+	_, openPort := listenUDP(t, true)
+	_, silentPort := listenUDP(t, false)
+	closed, closedPort := listenUDP(t, false)
+	closed.Close()
+
+	var tests = []struct {
+		port  uint64
+		state string
+	}{
+		{openPort, UDPOpen},
+		{silentPort, UDPOpenFiltered},
+		{closedPort, UDPClosed},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		state, err := LookupUDPPort(ctx, net.ParseIP("127.0.0.1"), tt.port, []byte("ping\n"))
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != tt.state {
+			t.Errorf("port %d: got state %q, want %q", tt.port, state, tt.state)
+		}
+	}
+}
+
+func TestUDPProbes(t *testing.T) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(DNSProbe()); err != nil {
+		t.Fatal(err)
+	}
+	q, err := p.Question()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Type != dnsmessage.TypeNS || q.Name.String() != "." {
+		t.Errorf("got question %s, want NS query for root", q.GoString())
+	}
+	wg := WireGuardProbe()
+	if got, want := len(wg), 148; got != want {
+		t.Errorf("got %d bytes, want %d", got, want)
+	}
+	if wg[0] != 1 {
+		t.Errorf("got message type %d, want %d", wg[0], 1)
+	}
+}
@@ -0,0 +1,161 @@
+// Package ssh implements an SSH server that writes the address of the client,
+// its geo summary, client version and offered public keys, and then
+// disconnects.
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mpolden/echoip/http"
+	"github.com/mpolden/echoip/internal/listener"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Maximum time spent on a connection, including authentication and the lookup
+const connTimeout = 30 * time.Second
+
+var errKeyRejected = errors.New("public key rejected")
+
+// Server is an SSH server which accepts any authentication, except public keys,
+// which are recorded and rejected so that the client offers all of them.
+type Server struct {
+	hostKey gossh.Signer
+	lookup  func(context.Context, net.IP) (http.Response, error)
+
+	listeners listener.Tracker
+}
+
+// New creates a new SSH server using the private host key in hostKeyFile, and
+// lookup to look up the client IP.
+func New(hostKeyFile string, lookup func(context.Context, net.IP) (http.Response, error)) (*Server, error) {
+	b, err := os.ReadFile(hostKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	hostKey, err := gossh.ParsePrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", hostKeyFile, err)
+	}
+	return &Server{hostKey: hostKey, lookup: lookup}, nil
+}
+
+// ListenAndServe listens on addr and serves connections until Close is called.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Close stops the server.
+func (s *Server) Close() error { return s.listeners.Close() }
+
+// Serve serves connections accepted by l until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	return s.listeners.Serve(l, s.serveConn)
+}
+
+// config returns the server configuration of a single connection, recording
+// the fingerprints of public keys offered by the client in keys.
+func (s *Server) config(keys *[]string) *gossh.ServerConfig {
+	config := &gossh.ServerConfig{
+		// Every offered key is a failed attempt, so the number of attempts is
+		// only bounded by connTimeout
+		MaxAuthTries: -1,
+		PublicKeyCallback: func(_ gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			*keys = append(*keys, gossh.FingerprintSHA256(key)+" ("+key.Type()+")")
+			return nil, errKeyRejected
+		},
+		PasswordCallback: func(gossh.ConnMetadata, []byte) (*gossh.Permissions, error) {
+			return nil, nil
+		},
+		KeyboardInteractiveCallback: func(gossh.ConnMetadata, gossh.KeyboardInteractiveChallenge) (*gossh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(s.hostKey)
+	return config
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	deadline := time.Now().Add(connTimeout)
+	conn.SetDeadline(deadline)
+	var keys []string
+	sshConn, chans, reqs, err := gossh.NewServerConn(conn, s.config(&keys))
+	if err != nil {
+		return
+	}
+	defer sshConn.Close()
+	go gossh.DiscardRequests(reqs)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(gossh.Prohibited, "only session channels are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		s.serveSession(channel, requests, s.message(ctx, addr.IP, string(sshConn.ClientVersion()), keys))
+		// Only the first session is served
+		return
+	}
+}
+
+// serveSession writes message to channel, and closes the channel when the client
+// requests a shell or command. The message is written as soon as the channel is
+// accepted, so that clients requesting neither also receive it.
+func (s *Server) serveSession(channel gossh.Channel, requests <-chan *gossh.Request, message string) {
+	defer channel.Close()
+	// A pseudo-terminal may be requested after the message is written, in which
+	// case the terminal of the client is in raw mode
+	io.WriteString(channel, strings.ReplaceAll(message, "\n", "\r\n"))
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			req.Reply(true, nil)
+		case "shell", "exec":
+			req.Reply(true, nil)
+			channel.SendRequest("exit-status", false, gossh.Marshal(struct{ Status uint32 }{0}))
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// message returns the message written to a client connecting from ip, with
+// client version and offered public keys.
+func (s *Server) message(ctx context.Context, ip net.IP, version string, keys []string) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	var sb strings.Builder
+	if response, err := s.lookup(ctx, ip); err != nil {
+		log.Printf("ssh: lookup of %s failed: %s", ip, err)
+		fmt.Fprintf(&sb, "ip: %s\n", ip)
+	} else {
+		sb.WriteString(response.Summary())
+	}
+	fmt.Fprintf(&sb, "client-version: %s\n", version)
+	for _, key := range keys {
+		fmt.Fprintf(&sb, "public-key: %s\n", key)
+	}
+	return sb.String()
+}
@@ -0,0 +1,123 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mpolden/echoip/http"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) (gossh.Signer, []byte) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := gossh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, pem.EncodeToMemory(block)
+}
+
+func TestServe(t *testing.T) {
+	hostKey, b := newSigner(t)
+	hostKeyFile := filepath.Join(t.TempDir(), "host_key")
+	if err := os.WriteFile(hostKeyFile, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(filepath.Join(t.TempDir(), "missing"), nil); err == nil {
+		t.Error("expected error for missing host key")
+	}
+	s, err := New(hostKeyFile, func(_ context.Context, ip net.IP) (http.Response, error) {
+		return http.Response{IP: ip, Country: "Elbonia", ASN: "AS59795"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Serve(l) }()
+
+	// More keys than the default limit of authentication attempts
+	var keys []gossh.Signer
+	for i := 0; i < 8; i++ {
+		key, _ := newSigner(t)
+		keys = append(keys, key)
+	}
+	dial := func() *gossh.Client {
+		client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+			User: "echoip",
+			Auth: []gossh.AuthMethod{gossh.PublicKeys(keys...), gossh.Password("")},
+			HostKeyCallback: func(_ string, _ net.Addr, key gossh.PublicKey) error {
+				if gossh.FingerprintSHA256(key) != gossh.FingerprintSHA256(hostKey.PublicKey()) {
+					t.Errorf("unexpected host key %s", gossh.FingerprintSHA256(key))
+				}
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	client := dial()
+	defer client.Close()
+	if _, _, err := client.OpenChannel("direct-tcpip", nil); err == nil {
+		t.Error("expected error when opening non-session channel")
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := session.Output("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ip: 127.0.0.1\r\n" +
+		"country: Elbonia\r\n" +
+		"asn: AS59795\r\n" +
+		"client-version: SSH-2.0-Go\r\n"
+	for _, key := range keys {
+		want += "public-key: " + gossh.FingerprintSHA256(key.PublicKey()) + " (ssh-ed25519)\r\n"
+	}
+	if got := string(out); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// The message is written without requesting a shell or command
+	client = dial()
+	defer client.Close()
+	channel, _, err := client.OpenChannel("session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(channel, buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Errorf("want nil error after close, got %s", err)
+	}
+}
@@ -0,0 +1,299 @@
+// Package stun implements a server answering STUN Binding requests, as defined
+// by RFC 5389, with the address the request was received from.
+package stun
+
+import (
+	"encoding/binary"
+	"errors"
+	"expvar"
+	"hash/crc32"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/mpolden/echoip/internal/listener"
+)
+
+const (
+	headerSize  = 20
+	magicCookie = 0x2112a442
+	// XOR-ed with the CRC-32 of the message in the FINGERPRINT attribute
+	fingerprintXOR = 0x5354554e
+	// Maximum size of a message received over TCP
+	maxMessageSize = 1 << 12
+	// Maximum time a TCP connection may be idle
+	tcpIdleTimeout = 10 * time.Second
+	software       = "echoip"
+)
+
+// Message types
+const (
+	bindingRequest       = 0x0001
+	bindingSuccess       = 0x0101
+	bindingError         = 0x0111
+	classMask            = 0x0110
+	classRequest         = 0x0000
+	methodMask           = 0x3eef
+	methodBinding        = 0x0001
+	attrMappedAddress    = 0x0001
+	attrErrorCode        = 0x0009
+	attrUnknownAttrs     = 0x000a
+	attrXORMappedAddress = 0x0020
+	attrSoftware         = 0x8022
+	attrFingerprint      = 0x8028
+)
+
+var errInvalidMessage = errors.New("invalid STUN message")
+
+// metrics counts requests and errors per transport, e.g. udp_requests and
+// tcp_errors. Errors are invalid messages and failed writes. The counters are
+// published by expvar as "stun".
+var metrics = expvar.NewMap("stun")
+
+// Server is a STUN server answering Binding requests over UDP and TCP.
+type Server struct {
+	listeners listener.Tracker
+}
+
+// New creates a new STUN server.
+func New() *Server { return &Server{} }
+
+// ListenAndServe listens on addr, over both UDP and TCP, and serves requests
+// until Close is called.
+func (s *Server) ListenAndServe(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	errCh := make(chan error, 2)
+	go func() { errCh <- s.ServeUDP(pc) }()
+	go func() { errCh <- s.ServeTCP(l) }()
+	err = <-errCh
+	if err != nil {
+		// Stop the other listener
+		pc.Close()
+		l.Close()
+	}
+	return err
+}
+
+// Close stops the server.
+func (s *Server) Close() error { return s.listeners.Close() }
+
+// ServeUDP serves requests received on pc until Close is called.
+func (s *Server) ServeUDP(pc net.PacketConn) error {
+	if !s.listeners.Track(pc) {
+		return nil
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if s.listeners.Closed() {
+				return nil
+			}
+			return err
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		metrics.Add("udp_requests", 1)
+		response, err := handle(buf[:n], udpAddr.IP, udpAddr.Port)
+		if err != nil {
+			metrics.Add("udp_errors", 1)
+			continue
+		}
+		if response == nil {
+			continue
+		}
+		if _, err := pc.WriteTo(response, addr); err != nil {
+			metrics.Add("udp_errors", 1)
+			log.Printf("stun: failed to write response to %s: %s", addr, err)
+		}
+	}
+}
+
+// ServeTCP serves requests received on connections accepted by l until Close is
+// called.
+func (s *Server) ServeTCP(l net.Listener) error {
+	return s.listeners.Serve(l, serveConn)
+}
+
+func serveConn(conn net.Conn) {
+	defer conn.Close()
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	buf := make([]byte, maxMessageSize)
+	for {
+		conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+		if _, err := io.ReadFull(conn, buf[:headerSize]); err != nil {
+			return
+		}
+		size := headerSize + int(binary.BigEndian.Uint16(buf[2:4]))
+		if size > len(buf) {
+			return
+		}
+		if _, err := io.ReadFull(conn, buf[headerSize:size]); err != nil {
+			return
+		}
+		metrics.Add("tcp_requests", 1)
+		response, err := handle(buf[:size], addr.IP, addr.Port)
+		if err != nil {
+			// Framing is lost on invalid messages
+			metrics.Add("tcp_errors", 1)
+			log.Printf("stun: closing connection from %s: %s", addr, err)
+			return
+		}
+		if response == nil {
+			continue
+		}
+		if _, err := conn.Write(response); err != nil {
+			metrics.Add("tcp_errors", 1)
+			log.Printf("stun: failed to write response to %s: %s", addr, err)
+			return
+		}
+	}
+}
+
+// attribute is a STUN attribute.
+type attribute struct {
+	typ   uint16
+	value []byte
+}
+
+// parse parses the STUN message in b, returning its type, transaction ID and
+// attributes.
+func parse(b []byte) (uint16, []byte, []attribute, error) {
+	if len(b) < headerSize || b[0]&0xc0 != 0 || binary.BigEndian.Uint32(b[4:8]) != magicCookie {
+		return 0, nil, nil, errInvalidMessage
+	}
+	typ := binary.BigEndian.Uint16(b[0:2])
+	size := int(binary.BigEndian.Uint16(b[2:4]))
+	if size%4 != 0 || headerSize+size != len(b) {
+		return 0, nil, nil, errInvalidMessage
+	}
+	var attrs []attribute
+	for rest := b[headerSize:]; len(rest) > 0; {
+		if len(rest) < 4 {
+			return 0, nil, nil, errInvalidMessage
+		}
+		attrType := binary.BigEndian.Uint16(rest[0:2])
+		attrSize := int(binary.BigEndian.Uint16(rest[2:4]))
+		padded := (attrSize + 3) &^ 3
+		if len(rest) < 4+padded {
+			return 0, nil, nil, errInvalidMessage
+		}
+		attrs = append(attrs, attribute{attrType, rest[4 : 4+attrSize]})
+		rest = rest[4+padded:]
+	}
+	return typ, b[8:headerSize], attrs, nil
+}
+
+// handle returns the response to the message in b, received from ip and port.
+// It returns a nil response if the message should be ignored, and an error if
+// the message is not a valid STUN message.
+func handle(b []byte, ip net.IP, port int) ([]byte, error) {
+	typ, txID, attrs, err := parse(b)
+	if err != nil {
+		return nil, err
+	}
+	if typ&classMask != classRequest {
+		// Indications and responses are not answered
+		return nil, nil
+	}
+	if typ&methodMask != methodBinding {
+		return errorResponse(typ, txID, 400, "Bad Request", nil), nil
+	}
+	// Attributes in the range 0x0000-0x7fff must be understood, but Binding
+	// requests carry none that affect the response
+	var unknown []uint16
+	for _, attr := range attrs {
+		if attr.typ < 0x8000 {
+			unknown = append(unknown, attr.typ)
+		}
+	}
+	if len(unknown) > 0 {
+		return errorResponse(typ, txID, 420, "Unknown Attribute", unknown), nil
+	}
+	m := newMessage(bindingSuccess, txID)
+	m.addAddress(attrXORMappedAddress, ip, port, txID)
+	m.addAddress(attrMappedAddress, ip, port, nil)
+	m.add(attrSoftware, []byte(software))
+	m.addFingerprint()
+	return m.b, nil
+}
+
+func errorResponse(typ uint16, txID []byte, code int, reason string, unknown []uint16) []byte {
+	m := newMessage(bindingError|typ&methodMask, txID)
+	value := []byte{0, 0, byte(code / 100), byte(code % 100)}
+	m.add(attrErrorCode, append(value, reason...))
+	if len(unknown) > 0 {
+		var value []byte
+		for _, typ := range unknown {
+			value = binary.BigEndian.AppendUint16(value, typ)
+		}
+		m.add(attrUnknownAttrs, value)
+	}
+	m.add(attrSoftware, []byte(software))
+	m.addFingerprint()
+	return m.b
+}
+
+type message struct{ b []byte }
+
+func newMessage(typ uint16, txID []byte) *message {
+	b := make([]byte, headerSize, 128)
+	binary.BigEndian.PutUint16(b[0:2], typ)
+	binary.BigEndian.PutUint32(b[4:8], magicCookie)
+	copy(b[8:headerSize], txID)
+	return &message{b}
+}
+
+func (m *message) add(typ uint16, value []byte) {
+	m.b = binary.BigEndian.AppendUint16(m.b, typ)
+	m.b = binary.BigEndian.AppendUint16(m.b, uint16(len(value)))
+	m.b = append(m.b, value...)
+	for len(m.b)%4 != 0 {
+		m.b = append(m.b, 0)
+	}
+	binary.BigEndian.PutUint16(m.b[2:4], uint16(len(m.b)-headerSize))
+}
+
+// addAddress adds an address attribute. If txID is non-nil, the address is
+// XOR-ed with the magic cookie and txID, as in XOR-MAPPED-ADDRESS.
+func (m *message) addAddress(typ uint16, ip net.IP, port int, txID []byte) {
+	family, addr := byte(0x01), ip.To4()
+	if addr == nil {
+		family, addr = 0x02, ip.To16()
+	}
+	addr = append(net.IP(nil), addr...)
+	if txID != nil {
+		port ^= magicCookie >> 16
+		key := binary.BigEndian.AppendUint32(nil, magicCookie)
+		key = append(key, txID...)
+		for i := range addr {
+			addr[i] ^= key[i]
+		}
+	}
+	value := []byte{0, family}
+	value = binary.BigEndian.AppendUint16(value, uint16(port))
+	m.add(typ, append(value, addr...))
+}
+
+// addFingerprint adds the FINGERPRINT attribute, which must be the last
+// attribute.
+func (m *message) addFingerprint() {
+	// The length in the header must include the fingerprint attribute
+	binary.BigEndian.PutUint16(m.b[2:4], uint16(len(m.b)-headerSize+8))
+	crc := crc32.ChecksumIEEE(m.b) ^ fingerprintXOR
+	m.add(attrFingerprint, binary.BigEndian.AppendUint32(nil, crc))
+}
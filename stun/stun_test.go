@@ -0,0 +1,260 @@
+package stun
+
+import (
+	"encoding/binary"
+	"expvar"
+	"hash/crc32"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func request(typ uint16, attrs ...attribute) []byte {
+	txID := []byte("0123456789ab")
+	m := newMessage(typ, txID)
+	for _, attr := range attrs {
+		m.add(attr.typ, attr.value)
+	}
+	return m.b
+}
+
+// decodeAddress decodes the address attribute of type typ in the response b.
+func decodeAddress(t *testing.T, b []byte, typ uint16) *net.UDPAddr {
+	_, txID, attrs, err := parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, attr := range attrs {
+		if attr.typ != typ {
+			continue
+		}
+		port := int(binary.BigEndian.Uint16(attr.value[2:4]))
+		ip := append(net.IP(nil), attr.value[4:]...)
+		if typ == attrXORMappedAddress {
+			port ^= magicCookie >> 16
+			key := append(binary.BigEndian.AppendUint32(nil, magicCookie), txID...)
+			for i := range ip {
+				ip[i] ^= key[i]
+			}
+		}
+		return &net.UDPAddr{IP: ip, Port: port}
+	}
+	t.Fatalf("attribute %#04x not found", typ)
+	return nil
+}
+
+func verifyFingerprint(t *testing.T, b []byte) {
+	if len(b) < headerSize+8 {
+		t.Fatal("message too short")
+	}
+	attr := b[len(b)-8:]
+	if binary.BigEndian.Uint16(attr[0:2]) != attrFingerprint {
+		t.Fatal("last attribute is not FINGERPRINT")
+	}
+	want := crc32.ChecksumIEEE(b[:len(b)-8]) ^ fingerprintXOR
+	if got := binary.BigEndian.Uint32(attr[4:8]); got != want {
+		t.Errorf("want fingerprint %#08x, got %#08x", want, got)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	var tests = []struct {
+		ip   string
+		port int
+	}{
+		{"192.0.2.1", 32853},
+		{"2001:db8::1", 1234},
+	}
+	for _, tt := range tests {
+		response, err := handle(request(bindingRequest), net.ParseIP(tt.ip), tt.port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyFingerprint(t, response)
+		typ, txID, _, err := parse(response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != bindingSuccess {
+			t.Errorf("want type %#04x, got %#04x", bindingSuccess, typ)
+		}
+		if string(txID) != "0123456789ab" {
+			t.Errorf("want transaction ID %q, got %q", "0123456789ab", txID)
+		}
+		for _, attrType := range []uint16{attrXORMappedAddress, attrMappedAddress} {
+			addr := decodeAddress(t, response, attrType)
+			if !addr.IP.Equal(net.ParseIP(tt.ip)) || addr.Port != tt.port {
+				t.Errorf("attribute %#04x: want %s:%d, got %s", attrType, tt.ip, tt.port, addr)
+			}
+		}
+	}
+}
+
+func TestHandleErrors(t *testing.T) {
+	ip := net.ParseIP("192.0.2.1")
+	// Comprehension-optional attributes are ignored
+	if response, err := handle(request(bindingRequest, attribute{attrSoftware, []byte("client")}), ip, 1234); err != nil {
+		t.Fatal(err)
+	} else if typ, _, _, _ := parse(response); typ != bindingSuccess {
+		t.Errorf("want type %#04x, got %#04x", bindingSuccess, typ)
+	}
+
+	var tests = []struct {
+		msg     []byte
+		typ     uint16
+		code    int
+		unknown []byte
+	}{
+		{request(bindingRequest, attribute{0x0024, []byte{0, 0, 0, 1}}), bindingError, 420, []byte{0x00, 0x24}},
+		{request(0x0003), 0x0113, 400, nil}, // Allocate request
+	}
+	for _, tt := range tests {
+		response, err := handle(tt.msg, ip, 1234)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyFingerprint(t, response)
+		typ, _, attrs, err := parse(response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != tt.typ {
+			t.Errorf("want type %#04x, got %#04x", tt.typ, typ)
+		}
+		var code int
+		var unknown []byte
+		for _, attr := range attrs {
+			switch attr.typ {
+			case attrErrorCode:
+				code = int(attr.value[2])*100 + int(attr.value[3])
+			case attrUnknownAttrs:
+				unknown = attr.value
+			}
+		}
+		if code != tt.code {
+			t.Errorf("want error code %d, got %d", tt.code, code)
+		}
+		if !reflect.DeepEqual(unknown, tt.unknown) {
+			t.Errorf("want unknown attributes %v, got %v", tt.unknown, unknown)
+		}
+	}
+
+	// Indications are ignored
+	if response, err := handle(request(0x0011), ip, 1234); err != nil || response != nil {
+		t.Errorf("want indication to be ignored, got (%v, %v)", response, err)
+	}
+	invalid := [][]byte{
+		{},
+		request(bindingRequest)[:19],
+		append(request(bindingRequest), 0, 0, 0, 0),
+		// RFC 3489 request without magic cookie
+		append([]byte{0, 1, 0, 0, 0, 0, 0, 0}, "0123456789ab"...),
+	}
+	for _, msg := range invalid {
+		if _, err := handle(msg, ip, 1234); err == nil {
+			t.Errorf("handle(%v): expected error", msg)
+		}
+	}
+}
+
+// counter returns the value of the STUN metric key.
+func counter(key string) int64 {
+	if v, ok := metrics.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestServe(t *testing.T) {
+	s := New()
+	udpRequests, tcpRequests, tcpErrors := counter("udp_requests"), counter("tcp_requests"), counter("tcp_errors")
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 2)
+	go func() { errCh <- s.ServeUDP(pc) }()
+	go func() { errCh <- s.ServeTCP(l) }()
+
+	// UDP
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(request(bindingRequest)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decodeAddress(t, buf[:n], attrXORMappedAddress), conn.LocalAddr().(*net.UDPAddr); !got.IP.Equal(want.IP) || got.Port != want.Port {
+		t.Errorf("UDP: want %s, got %s", want, got)
+	}
+
+	// TCP, with two requests on the same connection
+	tcpConn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+	tcpConn.SetDeadline(time.Now().Add(5 * time.Second))
+	want := tcpConn.LocalAddr().(*net.TCPAddr)
+	for i := 0; i < 2; i++ {
+		if _, err := tcpConn.Write(request(bindingRequest)); err != nil {
+			t.Fatal(err)
+		}
+		header := make([]byte, headerSize)
+		if _, err := io.ReadFull(tcpConn, header); err != nil {
+			t.Fatal(err)
+		}
+		response := append(header, make([]byte, binary.BigEndian.Uint16(header[2:4]))...)
+		if _, err := io.ReadFull(tcpConn, response[headerSize:]); err != nil {
+			t.Fatal(err)
+		}
+		if got := decodeAddress(t, response, attrXORMappedAddress); !got.IP.Equal(want.IP) || got.Port != want.Port {
+			t.Errorf("TCP #%d: want %s, got %s", i, want, got)
+		}
+	}
+
+	// An invalid message closes the TCP connection
+	if _, err := tcpConn.Write(make([]byte, headerSize)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tcpConn.Read(buf); err != io.EOF {
+		t.Errorf("want EOF after invalid message, got %v", err)
+	}
+
+	var metricTests = []struct {
+		key  string
+		from int64
+		want int64
+	}{
+		{"udp_requests", udpRequests, 1},
+		{"tcp_requests", tcpRequests, 3},
+		{"tcp_errors", tcpErrors, 1},
+	}
+	for _, tt := range metricTests {
+		if got := counter(tt.key) - tt.from; got != tt.want {
+			t.Errorf("want %d new %s, got %d", tt.want, tt.key, got)
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errCh; err != nil {
+			t.Errorf("want nil error after close, got %s", err)
+		}
+	}
+}
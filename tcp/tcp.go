@@ -0,0 +1,80 @@
+// Package tcp implements a plain TCP server that writes the IP address of the
+// client and closes the connection, for use with nc or telnet.
+package tcp
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/mpolden/echoip/http"
+	"github.com/mpolden/echoip/internal/listener"
+)
+
+// Maximum time spent on a connection, including the lookup in verbose mode
+const connTimeout = 10 * time.Second
+
+// Server is a TCP server writing the IP address of each client.
+type Server struct {
+	// Lookup, if set, enables verbose mode, where the summary of the response
+	// returned by Lookup is written instead of only the IP address.
+	Lookup func(context.Context, net.IP) (http.Response, error)
+
+	listeners listener.Tracker
+}
+
+// New creates a new TCP server. If lookup is non-nil, the server runs in
+// verbose mode.
+func New(lookup func(context.Context, net.IP) (http.Response, error)) *Server {
+	return &Server{Lookup: lookup}
+}
+
+// ListenAndServe listens on addr and serves connections until Close is called.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Close stops the server.
+func (s *Server) Close() error { return s.listeners.Close() }
+
+// Serve serves connections accepted by l until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	return s.listeners.Serve(l, s.serveConn)
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	deadline := time.Now().Add(connTimeout)
+	conn.SetDeadline(deadline)
+	if _, err := io.WriteString(conn, s.message(deadline, addr.IP)); err != nil {
+		log.Printf("tcp: failed to write response to %s: %s", addr, err)
+	}
+}
+
+// message returns the message written to a client connecting from ip.
+func (s *Server) message(deadline time.Time, ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if s.Lookup == nil {
+		return ip.String() + "\n"
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	response, err := s.Lookup(ctx, ip)
+	if err != nil {
+		log.Printf("tcp: lookup of %s failed: %s", ip, err)
+		return ip.String() + "\n"
+	}
+	return response.Summary()
+}
@@ -0,0 +1,61 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mpolden/echoip/http"
+)
+
+func readAll(t *testing.T, addr string) string {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestServe(t *testing.T) {
+	lookup := func(_ context.Context, ip net.IP) (http.Response, error) {
+		return http.Response{IP: ip, Country: "Elbonia", CountryISO: "EB", ASN: "AS59795"}, nil
+	}
+	failingLookup := func(context.Context, net.IP) (http.Response, error) {
+		return http.Response{}, errors.New("lookup failed")
+	}
+	var tests = []struct {
+		lookup func(context.Context, net.IP) (http.Response, error)
+		out    string
+	}{
+		{nil, "127.0.0.1\n"},
+		{lookup, "ip: 127.0.0.1\ncountry: Elbonia\ncountry-iso: EB\nasn: AS59795\n"},
+		{failingLookup, "127.0.0.1\n"},
+	}
+	for i, tt := range tests {
+		s := New(tt.lookup)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		errCh := make(chan error, 1)
+		go func() { errCh <- s.Serve(l) }()
+		if got := readAll(t, l.Addr().String()); got != tt.out {
+			t.Errorf("#%d: want %q, got %q", i, tt.out, got)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errCh; err != nil {
+			t.Errorf("#%d: want nil error after close, got %s", i, err)
+		}
+	}
+}
@@ -1,17 +1,160 @@
 package useragent
 
 import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
 	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Device types
+const (
+	Desktop = "desktop"
+	Mobile  = "mobile"
+	Tablet  = "tablet"
+	Bot     = "bot"
 )
 
+//go:embed rules.yaml
+var defaultRules []byte
+
+var defaultParser = mustNew(defaultRules)
+
 type UserAgent struct {
-	Product  string `json:"product,omitempty"`
-	Version  string `json:"version,omitempty"`
-	Comment  string `json:"comment,omitempty"`
-	RawValue string `json:"raw_value,omitempty"`
+	Product        string `json:"product,omitempty"`
+	Version        string `json:"version,omitempty"`
+	Comment        string `json:"comment,omitempty"`
+	RawValue       string `json:"raw_value,omitempty"`
+	Browser        string `json:"browser,omitempty"`
+	BrowserVersion string `json:"browser_version,omitempty"`
+	Engine         string `json:"engine,omitempty"`
+	EngineVersion  string `json:"engine_version,omitempty"`
+	OS             string `json:"os,omitempty"`
+	OSVersion      string `json:"os_version,omitempty"`
+	Device         string `json:"device,omitempty"`
+	Bot            bool   `json:"bot,omitempty"`
+}
+
+// rule matches a User-Agent against a regex and produces a name and version
+// from its submatches.
+type rule struct {
+	Regex   string `yaml:"regex"`
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	re      *regexp.Regexp
+}
+
+func (r *rule) match(s string) (name, version string, ok bool) {
+	m := r.re.FindStringSubmatchIndex(s)
+	if m == nil {
+		return "", "", false
+	}
+	name = string(r.re.ExpandString(nil, r.Name, s, m))
+	version = string(r.re.ExpandString(nil, r.Version, s, m))
+	return name, strings.ReplaceAll(version, "_", "."), true
+}
+
+type rules struct {
+	Bots     []rule `yaml:"bots"`
+	Browsers []rule `yaml:"browsers"`
+	Engines  []rule `yaml:"engines"`
+	OS       []rule `yaml:"os"`
+	Devices  []rule `yaml:"devices"`
+}
+
+// Parser parses User-Agent headers using a set of rules. See rules.yaml for
+// the format of the rules.
+type Parser struct{ rules rules }
+
+// New creates a parser from the YAML-encoded rules in b.
+func New(b []byte) (*Parser, error) {
+	var rules rules
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("invalid user agent rules: %w", err)
+	}
+	sections := []struct {
+		name  string
+		rules []rule
+	}{
+		{"bots", rules.Bots},
+		{"browsers", rules.Browsers},
+		{"engines", rules.Engines},
+		{"os", rules.OS},
+		{"devices", rules.Devices},
+	}
+	for _, section := range sections {
+		for i := range section.rules {
+			r := &section.rules[i]
+			re, err := regexp.Compile(r.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid user agent rule %d in %s: %w", i+1, section.name, err)
+			}
+			if section.name == "devices" && r.Name != Desktop && r.Name != Mobile && r.Name != Tablet {
+				return nil, fmt.Errorf("invalid user agent rule %d in %s: unknown device %q", i+1, section.name, r.Name)
+			}
+			r.re = re
+		}
+	}
+	return &Parser{rules: rules}, nil
+}
+
+// Load creates a parser from the rules in the YAML file filename.
+func Load(filename string) (*Parser, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return New(b)
+}
+
+func mustNew(b []byte) *Parser {
+	p, err := New(b)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func firstMatch(rules []rule, s string) (name, version string, ok bool) {
+	for i := range rules {
+		if name, version, ok := rules[i].match(s); ok {
+			return name, version, true
+		}
+	}
+	return "", "", false
+}
+
+// Parse parses s using the embedded rules.
+func Parse(s string) UserAgent { return defaultParser.Parse(s) }
+
+// Parse parses s into its product, version and comment, and the browser,
+// engine, operating system and device type matched by the rules of p.
+func (p *Parser) Parse(s string) UserAgent {
+	ua := ParseProduct(s)
+	if s == "" {
+		return ua
+	}
+	if name, version, ok := firstMatch(p.rules.Bots, s); ok {
+		ua.Browser, ua.BrowserVersion = name, version
+		ua.Device, ua.Bot = Bot, true
+	} else {
+		ua.Browser, ua.BrowserVersion, _ = firstMatch(p.rules.Browsers, s)
+		ua.Device, _, _ = firstMatch(p.rules.Devices, s)
+	}
+	ua.Engine, ua.EngineVersion, _ = firstMatch(p.rules.Engines, s)
+	ua.OS, ua.OSVersion, _ = firstMatch(p.rules.OS, s)
+	return ua
 }
 
-func Parse(s string) UserAgent {
+// ParseProduct splits s into the product, version and comment, without
+// matching any rules.
+func ParseProduct(s string) UserAgent {
 	parts := strings.SplitN(s, "/", 2)
 	var version, comment string
 	if len(parts) > 1 {
@@ -37,3 +37,91 @@ func TestParse(t *testing.T) {
 		}
 	}
 }
+
+func TestParseProduct(t *testing.T) {
+	in := "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	want := UserAgent{
+		Product:  "Mozilla",
+		Version:  "5.0",
+		Comment:  "(X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		RawValue: in,
+	}
+	if got := ParseProduct(in); got != want {
+		t.Errorf("ParseProduct(%q) = %+v, want %+v", in, got, want)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out UserAgent
+	}{
+		{"curl/8.5.0", UserAgent{Browser: "curl", BrowserVersion: "8.5.0"}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			UserAgent{Browser: "Chrome", BrowserVersion: "120.0.0.0", Engine: "Blink", EngineVersion: "120.0.0.0", OS: "Windows", OSVersion: "10", Device: Desktop}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			UserAgent{Browser: "Edge", BrowserVersion: "120.0.2210.91", Engine: "Blink", EngineVersion: "120.0.0.0", OS: "Windows", OSVersion: "10", Device: Desktop}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/70.0.3538.102 Safari/537.36 Edge/18.19045",
+			UserAgent{Browser: "Edge", BrowserVersion: "18.19045", Engine: "EdgeHTML", EngineVersion: "18.19045", OS: "Windows", OSVersion: "10", Device: Desktop}},
+		{"Mozilla/5.0 (Windows NT 6.1; WOW64; Trident/7.0; rv:11.0) like Gecko",
+			UserAgent{Browser: "Internet Explorer", BrowserVersion: "11.0", Engine: "Trident", EngineVersion: "7.0", OS: "Windows", OSVersion: "7", Device: Desktop}},
+		{"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			UserAgent{Browser: "Firefox", BrowserVersion: "121.0", Engine: "Gecko", EngineVersion: "121.0", OS: "Linux", Device: Desktop}},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+			UserAgent{Browser: "Safari", BrowserVersion: "17.2", Engine: "WebKit", EngineVersion: "605.1.15", OS: "macOS", OSVersion: "10.15.7", Device: Desktop}},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+			UserAgent{Browser: "Safari", BrowserVersion: "17.2", Engine: "WebKit", EngineVersion: "605.1.15", OS: "iOS", OSVersion: "17.2", Device: Mobile}},
+		{"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1",
+			UserAgent{Browser: "Chrome", BrowserVersion: "120.0.6099.119", Engine: "WebKit", EngineVersion: "605.1.15", OS: "iOS", OSVersion: "16.6", Device: Tablet}},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36",
+			UserAgent{Browser: "Chrome", BrowserVersion: "120.0.6099.144", Engine: "Blink", EngineVersion: "120.0.6099.144", OS: "Android", OSVersion: "14", Device: Mobile}},
+		{"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Safari/537.36",
+			UserAgent{Browser: "Samsung Internet", BrowserVersion: "23.0", Engine: "Blink", EngineVersion: "115.0.0.0", OS: "Android", OSVersion: "13", Device: Tablet}},
+		{"Mozilla/5.0 (X11; CrOS x86_64 15633.69.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.6045.212 Safari/537.36",
+			UserAgent{Browser: "Chrome", BrowserVersion: "119.0.6045.212", Engine: "Blink", EngineVersion: "119.0.6045.212", OS: "ChromeOS", OSVersion: "15633.69.0", Device: Desktop}},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			UserAgent{Browser: "Googlebot", BrowserVersion: "2.1", Device: Bot, Bot: true}},
+		{"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm) Chrome/116.0.1938.76 Safari/537.36",
+			UserAgent{Browser: "bingbot", BrowserVersion: "2.0", Engine: "Blink", EngineVersion: "116.0.1938.76", Device: Bot, Bot: true}},
+		{"ExampleCrawler/1.0", UserAgent{Browser: "ExampleCrawler", BrowserVersion: "1.0", Device: Bot, Bot: true}},
+	}
+	for _, tt := range tests {
+		ua := Parse(tt.in)
+		// Product, version and comment are covered by TestParse
+		ua.Product, ua.Version, ua.Comment, ua.RawValue = "", "", "", ""
+		if ua != tt.out {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.in, ua, tt.out)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	p, err := New([]byte(`
+browsers:
+  - regex: '^Example/(\d+)_(\d+)'
+    name: Example Browser
+    version: '${1}_${2}'
+devices:
+  - regex: 'Example'
+    name: mobile
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := UserAgent{Product: "Example", Version: "1_2", RawValue: "Example/1_2", Browser: "Example Browser", BrowserVersion: "1.2", Device: Mobile}
+	if got := p.Parse("Example/1_2"); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	var invalid = []string{
+		"browsers: foo",
+		"browsers:\n  - regex: '('\n",
+		"devices:\n  - regex: 'x'\n    name: phone\n",
+		"browsers:\n  - regexp: 'x'\n",
+	}
+	for _, in := range invalid {
+		if _, err := New([]byte(in)); err == nil {
+			t.Errorf("New(%q): expected error", in)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Package whois implements a WHOIS server, as defined by RFC 3912, answering
+// queries for an IP address with its geo and network information.
+package whois
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mpolden/echoip/http"
+	"github.com/mpolden/echoip/internal/listener"
+)
+
+const (
+	// Maximum time spent on a connection, including the lookup
+	connTimeout = 10 * time.Second
+	// Maximum size of a query
+	maxQuerySize = 1024
+)
+
+// Server is a WHOIS server. An empty query is answered with the information of
+// the client.
+type Server struct {
+	lookup func(context.Context, net.IP) (http.Response, error)
+
+	listeners listener.Tracker
+}
+
+// New creates a new WHOIS server using lookup to look up IP addresses.
+func New(lookup func(context.Context, net.IP) (http.Response, error)) *Server {
+	return &Server{lookup: lookup}
+}
+
+// ListenAndServe listens on addr and serves queries until Close is called.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Close stops the server.
+func (s *Server) Close() error { return s.listeners.Close() }
+
+// Serve serves queries received on connections accepted by l until Close is
+// called.
+func (s *Server) Serve(l net.Listener) error {
+	return s.listeners.Serve(l, s.serveConn)
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	deadline := time.Now().Add(connTimeout)
+	conn.SetDeadline(deadline)
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 256), maxQuerySize)
+	var query string
+	if scanner.Scan() {
+		query = scanner.Text()
+	} else if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			io.WriteString(conn, "% Error: query too long\n")
+		}
+		return
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if _, err := io.WriteString(conn, s.answer(ctx, query, addr.IP)); err != nil {
+		log.Printf("whois: failed to write response to %s: %s", addr, err)
+	}
+}
+
+// answer returns the answer to query, received from clientIP.
+func (s *Server) answer(ctx context.Context, query string, clientIP net.IP) string {
+	query = strings.TrimSpace(query)
+	ip := clientIP
+	if query != "" {
+		ip = net.ParseIP(query)
+		if ip == nil {
+			return fmt.Sprintf("%% Error: invalid query %q: not an IP address\n", query)
+		}
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	response, err := s.lookup(ctx, ip)
+	if err != nil {
+		log.Printf("whois: lookup of %s failed: %s", ip, err)
+		return fmt.Sprintf("%% Error: lookup of %s failed\n", ip)
+	}
+	return format(response)
+}
+
+// format formats response as a WHOIS record. Empty values are omitted.
+func format(response http.Response) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%% Information related to %s\n\n", response.IP)
+	add := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(&sb, "%-16s%s\n", key+":", value)
+		}
+	}
+	add("ip", response.IP.String())
+	add("network", response.Network)
+	add("country", response.CountryISO)
+	add("country-name", response.Country)
+	add("city", response.City)
+	add("origin", response.ASN)
+	add("org-name", response.ASNOrg)
+	add("hostname", response.Hostname)
+	return sb.String()
+}
@@ -0,0 +1,104 @@
+package whois
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mpolden/echoip/http"
+)
+
+func lookup(_ context.Context, ip net.IP) (http.Response, error) {
+	if ip.Equal(net.ParseIP("192.0.2.2")) {
+		return http.Response{}, errors.New("lookup failed")
+	}
+	return http.Response{
+		IP:         ip,
+		Network:    "192.0.2.0/24",
+		Country:    "Elbonia",
+		CountryISO: "EB",
+		City:       "Bornyasherk",
+		ASN:        "AS59795",
+		ASNOrg:     "Hosting4Real",
+		Hostname:   "localhost",
+	}, nil
+}
+
+func TestAnswer(t *testing.T) {
+	s := New(lookup)
+	clientIP := net.ParseIP("192.0.2.1")
+	full := "% Information related to 192.0.2.1\n\n" +
+		"ip:             192.0.2.1\n" +
+		"network:        192.0.2.0/24\n" +
+		"country:        EB\n" +
+		"country-name:   Elbonia\n" +
+		"city:           Bornyasherk\n" +
+		"origin:         AS59795\n" +
+		"org-name:       Hosting4Real\n" +
+		"hostname:       localhost\n"
+	var tests = []struct {
+		query string
+		out   string
+	}{
+		{"", full},
+		{"  ", full},
+		{"192.0.2.1", full},
+		{" 192.0.2.1 ", full},
+		{"::ffff:192.0.2.1", full},
+		{"192.0.2.2", "% Error: lookup of 192.0.2.2 failed\n"},
+		{"example.com", "% Error: invalid query \"example.com\": not an IP address\n"},
+	}
+	for _, tt := range tests {
+		if got := s.answer(context.Background(), tt.query, clientIP); got != tt.out {
+			t.Errorf("answer(%q): want %q, got %q", tt.query, tt.out, got)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	s := New(func(_ context.Context, ip net.IP) (http.Response, error) {
+		return http.Response{IP: ip, ASN: "AS59795"}, nil
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Serve(l) }()
+
+	var tests = []struct {
+		query string
+		out   string
+	}{
+		{"\r\n", "% Information related to 127.0.0.1\n\nip:             127.0.0.1\norigin:         AS59795\n"},
+		{"192.0.2.1\r\n", "% Information related to 192.0.2.1\n\nip:             192.0.2.1\norigin:         AS59795\n"},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte(tt.query)); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != tt.out {
+			t.Errorf("query %q: want %q, got %q", tt.query, tt.out, got)
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Errorf("want nil error after close, got %s", err)
+	}
+}